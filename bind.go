@@ -0,0 +1,418 @@
+package canis
+
+import (
+	"encoding"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Returned when reading a request body that is larger than allowed, such as by request.DecodeBody()
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// The max memory used to parse multipart/form-data bodies before spilling to disk
+var BindMaxMemory int64 = 32 << 20
+
+// Describes a single field that failed to bind or validate
+type FieldError struct {
+	// Name of the struct field
+	Field string `json:"field"`
+	// Where the value came from; 'path', 'query', 'header', 'form', 'body' or 'validate'
+	Source string `json:"source"`
+	// Human readable reason the field failed
+	Message string `json:"message"`
+}
+
+func (self FieldError) Error() string {
+	if self.Field == "" {
+		return self.Source + ": " + self.Message
+	}
+	return self.Field + ": " + self.Message
+}
+
+// Returned by Bind() with every field that failed to bind or validate
+type BindError struct {
+	Fields []FieldError `json:"errors"`
+}
+
+func (self *BindError) Error() string {
+	msgs := make([]string, len(self.Fields))
+	for i, field := range self.Fields {
+		msgs[i] = field.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (self *BindError) add(field, source, msg string) {
+	self.Fields = append(self.Fields, FieldError{field, source, msg})
+}
+
+// Bind fills the struct pointed to by dest from the request. The body is decoded first
+// according to the Content-Type (JSON, XML or form), then fields tagged with
+// 'form', 'query', 'header' or 'path' are set from those sources, overriding any
+// value decoded from the body. A field tagged with several sources takes the
+// first one present in that order.
+//
+//	type UpdatePie struct {
+//		ID      int64    `path:"id" validate:"required,min=1"`
+//		Flavor  string   `json:"flavor" validate:"required,enum=apple|cherry|pecan"`
+//		Slices  int      `form:"slices" validate:"min=1,max=12"`
+//		Tags    []string `query:"tag"`
+//		Trace   string   `header:"X-Trace-Id" validate:"regex=^[a-f0-9]+$"`
+//	}
+//
+// Supported validation rules are 'required', 'min=N', 'max=N' (value for numbers,
+// length for strings, slices and maps), 'enum=a|b|c' and 'regex=EXPR'. Since a
+// regex may contain commas it must be the last rule in the tag. Fields without
+// 'required' are only validated when they hold a non zero value.
+//
+// All failures are collected and returned as a *BindError, unless the body is
+// larger than allowed in which case ErrBodyTooLarge is returned
+func Bind(ctx ParamContext, req *http.Request, dest interface{}) error {
+	value := reflect.ValueOf(dest)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("canis.Bind() requires a pointer to a struct; got %T", dest))
+	}
+	value = value.Elem()
+
+	errs := &BindError{}
+	form, err := bindBody(req, dest, errs)
+	if err != nil {
+		return err
+	}
+
+	bindFields(value, errs, func(field reflect.StructField) (string, []string, bool) {
+		if name, ok := field.Tag.Lookup("form"); ok && form != nil {
+			if values, ok := form[name]; ok {
+				return "form", values, true
+			}
+		}
+		if name, ok := field.Tag.Lookup("query"); ok {
+			if values, ok := req.URL.Query()[name]; ok {
+				return "query", values, true
+			}
+		}
+		if name, ok := field.Tag.Lookup("header"); ok {
+			if values, ok := req.Header[http.CanonicalHeaderKey(name)]; ok {
+				return "header", values, true
+			}
+		}
+		if name, ok := field.Tag.Lookup("path"); ok && ctx != nil {
+			if param, ok := ctx.Lookup(name); ok {
+				return "path", []string{param}, true
+			}
+		}
+		return "", nil, false
+	})
+
+	validateStruct(value, errs)
+
+	if len(errs.Fields) != 0 {
+		return errs
+	}
+	return nil
+}
+
+// Decode the request body into dest according to the Content-Type, returns the
+// parsed form values if the body was a form. Only ErrBodyTooLarge is returned,
+// other failures are added to errs
+func bindBody(req *http.Request, dest interface{}, errs *BindError) (map[string][]string, error) {
+	if req.Body == nil || req.ContentLength == 0 {
+		return nil, nil
+	}
+
+	contentType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	switch {
+	case contentType == "application/json" || strings.HasSuffix(contentType, "+json"):
+		if err := json.NewDecoder(req.Body).Decode(dest); err != nil && err != io.EOF {
+			return nil, bodyFailed(err, errs, "body", "invalid JSON; "+err.Error())
+		}
+	case contentType == "application/xml" || contentType == "text/xml" || strings.HasSuffix(contentType, "+xml"):
+		if err := xml.NewDecoder(req.Body).Decode(dest); err != nil && err != io.EOF {
+			return nil, bodyFailed(err, errs, "body", "invalid XML; "+err.Error())
+		}
+	case contentType == "application/x-www-form-urlencoded":
+		if err := req.ParseForm(); err != nil {
+			return nil, bodyFailed(err, errs, "form", err.Error())
+		}
+		return req.PostForm, nil
+	case contentType == "multipart/form-data":
+		if err := req.ParseMultipartForm(BindMaxMemory); err != nil {
+			return nil, bodyFailed(err, errs, "form", err.Error())
+		}
+		return req.MultipartForm.Value, nil
+	}
+	return nil, nil
+}
+
+// Record the failure to decode the body, unless the body was too large which is returned instead
+func bodyFailed(err error, errs *BindError, source, msg string) error {
+	if errors.Is(err, ErrBodyTooLarge) {
+		return ErrBodyTooLarge
+	}
+	errs.add("", source, msg)
+	return nil
+}
+
+type valueSource func(reflect.StructField) (source string, values []string, ok bool)
+
+// Walk the fields of the struct (including embedded structs) and assign the values found by lookup
+func bindFields(value reflect.Value, errs *BindError, lookup valueSource) {
+	kind := value.Type()
+	for i := 0; i < kind.NumField(); i++ {
+		field := kind.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			bindFields(value.Field(i), errs, lookup)
+			continue
+		}
+
+		source, values, ok := lookup(field)
+		if !ok {
+			continue
+		}
+		if err := setField(value.Field(i), values); err != nil {
+			errs.add(field.Name, source, err.Error())
+		}
+	}
+}
+
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	durationType        = reflect.TypeOf(time.Duration(0))
+	timeType            = reflect.TypeOf(time.Time{})
+)
+
+func setField(field reflect.Value, values []string) error {
+	if field.Kind() == reflect.Slice && field.Type().Elem().Kind() != reflect.Uint8 &&
+		!field.Addr().Type().Implements(textUnmarshalerType) {
+		slice := reflect.MakeSlice(field.Type(), len(values), len(values))
+		for i, value := range values {
+			if err := setValue(slice.Index(i), value); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+		return nil
+	}
+	return setValue(field, values[0])
+}
+
+func setValue(field reflect.Value, value string) error {
+	if field.Kind() == reflect.Ptr {
+		ptr := reflect.New(field.Type().Elem())
+		if err := setValue(ptr.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(ptr)
+		return nil
+	}
+
+	if field.CanAddr() && field.Addr().Type().Implements(textUnmarshalerType) {
+		return field.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	}
+
+	switch field.Type() {
+	case durationType:
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a valid duration", value)
+		}
+		field.SetInt(int64(duration))
+		return nil
+	case timeType:
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a valid RFC3339 time", value)
+		}
+		field.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a valid boolean", value)
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("'%s' is not a valid integer", value)
+		}
+		field.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("'%s' is not a valid unsigned integer", value)
+		}
+		field.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("'%s' is not a valid number", value)
+		}
+		field.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type '%s'", field.Type())
+	}
+	return nil
+}
+
+// Apply the 'validate' tag of each field in the struct
+func validateStruct(value reflect.Value, errs *BindError) {
+	kind := value.Type()
+	for i := 0; i < kind.NumField(); i++ {
+		field := kind.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			validateStruct(value.Field(i), errs)
+			continue
+		}
+		tag, ok := field.Tag.Lookup("validate")
+		if !ok || field.PkgPath != "" {
+			continue
+		}
+		for _, msg := range validateField(value.Field(i), tag) {
+			errs.add(field.Name, "validate", msg)
+		}
+	}
+}
+
+func validateField(field reflect.Value, tag string) []string {
+	var msgs []string
+
+	// Optional fields that were not provided are not validated
+	required := hasRule(tag, "required")
+	if !required && isZero(field) {
+		return nil
+	}
+	for field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			return []string{"is required"}
+		}
+		field = field.Elem()
+	}
+
+	for tag != "" {
+		var rule string
+		if strings.HasPrefix(tag, "regex=") {
+			rule, tag = tag, ""
+		} else if pos := strings.IndexByte(tag, ','); pos != -1 {
+			rule, tag = tag[:pos], tag[pos+1:]
+		} else {
+			rule, tag = tag, ""
+		}
+
+		name, arg := rule, ""
+		if pos := strings.IndexByte(rule, '='); pos != -1 {
+			name, arg = rule[:pos], rule[pos+1:]
+		}
+
+		switch name {
+		case "required":
+			if isZero(field) {
+				return append(msgs, "is required")
+			}
+		case "min", "max":
+			limit, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				panic(fmt.Sprintf("invalid validate rule '%s'", rule))
+			}
+			size, isLength := measure(field)
+			if name == "min" && size < limit {
+				msgs = append(msgs, fmt.Sprintf("must be at least %s%s", arg, lengthSuffix(isLength)))
+			}
+			if name == "max" && size > limit {
+				msgs = append(msgs, fmt.Sprintf("must be at most %s%s", arg, lengthSuffix(isLength)))
+			}
+		case "enum":
+			actual := fmt.Sprint(field.Interface())
+			found := false
+			for _, choice := range strings.Split(arg, "|") {
+				if choice == actual {
+					found = true
+					break
+				}
+			}
+			if !found {
+				msgs = append(msgs, fmt.Sprintf("must be one of '%s'", strings.Replace(arg, "|", "', '", -1)))
+			}
+		case "regex":
+			if field.Kind() != reflect.String {
+				panic(fmt.Sprintf("validate rule '%s' requires a string field", rule))
+			}
+			if !compileRegex(arg).MatchString(field.String()) {
+				msgs = append(msgs, fmt.Sprintf("must match '%s'", arg))
+			}
+		case "":
+		default:
+			panic(fmt.Sprintf("unknown validate rule '%s'", rule))
+		}
+	}
+	return msgs
+}
+
+func hasRule(tag, rule string) bool {
+	for _, item := range strings.Split(tag, ",") {
+		if item == rule {
+			return true
+		}
+	}
+	return false
+}
+
+func lengthSuffix(isLength bool) string {
+	if isLength {
+		return " in length"
+	}
+	return ""
+}
+
+// Returns the numeric value of the field, or its length for strings, slices and maps
+func measure(field reflect.Value) (float64, bool) {
+	switch field.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return float64(field.Len()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(field.Int()), false
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(field.Uint()), false
+	case reflect.Float32, reflect.Float64:
+		return field.Float(), false
+	}
+	panic(fmt.Sprintf("min and max validate rules do not support type '%s'", field.Type()))
+}
+
+func isZero(field reflect.Value) bool {
+	switch field.Kind() {
+	case reflect.Slice, reflect.Map:
+		return field.Len() == 0
+	}
+	return field.IsZero()
+}
+
+var regexCache sync.Map
+
+func compileRegex(expr string) *regexp.Regexp {
+	if cached, ok := regexCache.Load(expr); ok {
+		return cached.(*regexp.Regexp)
+	}
+	regex := regexp.MustCompile(expr)
+	regexCache.Store(expr, regex)
+	return regex
+}
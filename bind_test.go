@@ -0,0 +1,112 @@
+package canis
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+type bindPie struct {
+	ID      int64         `path:"id" validate:"required,min=1"`
+	Flavor  string        `json:"flavor" validate:"required,enum=apple|cherry"`
+	Slices  int           `json:"slices" query:"slices" validate:"min=1,max=12"`
+	Tags    []string      `query:"tag" validate:"max=2"`
+	Trace   string        `header:"X-Trace-Id" validate:"regex=^[a-f0-9]{2,}$"`
+	Bake    time.Duration `query:"bake"`
+	Crust   *bool         `query:"crust"`
+	Comment string        `form:"comment"`
+}
+
+func newBindContext(params ...Param) ParamContext {
+	return ParamContextImpl{context.Background(), Params(params)}
+}
+
+func TestBind(t *testing.T) {
+	req, _ := http.NewRequest("PUT", "/pies/10?slices=8&tag=a&tag=b&bake=45m&crust=true",
+		strings.NewReader(`{"flavor": "cherry", "slices": 2}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Trace-Id", "beef")
+
+	var pie bindPie
+	if err := Bind(newBindContext(Param{"id", "10"}), req, &pie); err != nil {
+		t.Fatal(err)
+	}
+
+	if pie.ID != 10 || pie.Flavor != "cherry" || pie.Slices != 8 || pie.Trace != "beef" {
+		t.Errorf("wrong values %+v", pie)
+	}
+	if len(pie.Tags) != 2 || pie.Tags[1] != "b" {
+		t.Errorf("wrong tags %v", pie.Tags)
+	}
+	if pie.Bake != 45*time.Minute {
+		t.Errorf("wrong duration %v", pie.Bake)
+	}
+	if pie.Crust == nil || !*pie.Crust {
+		t.Errorf("wrong crust %v", pie.Crust)
+	}
+}
+
+func TestBindForm(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/pies/1", strings.NewReader("comment=tasty"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var pie bindPie
+	err := Bind(newBindContext(Param{"id", "1"}), req, &pie)
+	if pie.Comment != "tasty" {
+		t.Errorf("wrong comment %q", pie.Comment)
+	}
+	// Flavor is required but missing
+	bindErr, ok := err.(*BindError)
+	if !ok || len(bindErr.Fields) != 1 || bindErr.Fields[0].Field != "Flavor" {
+		t.Errorf("expected Flavor to be required; got %v", err)
+	}
+}
+
+func TestBindErrors(t *testing.T) {
+	req, _ := http.NewRequest("PUT", "/pies/0?slices=20&tag=a&tag=b&tag=c&crust=maybe",
+		strings.NewReader(`{"flavor": "peach"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Trace-Id", "XYZ")
+
+	var pie bindPie
+	err := Bind(newBindContext(Param{"id", "0"}), req, &pie)
+	bindErr, ok := err.(*BindError)
+	if !ok {
+		t.Fatalf("expected *BindError got %v", err)
+	}
+
+	expected := map[string]string{
+		"Crust":  "query",
+		"ID":     "validate",
+		"Flavor": "validate",
+		"Slices": "validate",
+		"Tags":   "validate",
+		"Trace":  "validate",
+	}
+	for _, field := range bindErr.Fields {
+		if source, ok := expected[field.Field]; !ok || source != field.Source {
+			t.Errorf("unexpected error %+v", field)
+		}
+		delete(expected, field.Field)
+	}
+	for field := range expected {
+		t.Errorf("missing error for %s", field)
+	}
+}
+
+func TestBindInvalidBody(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/", strings.NewReader(`{"flavor": `))
+	req.Header.Set("Content-Type", "application/json")
+
+	var dest struct {
+		Flavor string `json:"flavor"`
+	}
+	err := Bind(newBindContext(), req, &dest)
+	bindErr, ok := err.(*BindError)
+	if !ok || bindErr.Fields[0].Source != "body" {
+		t.Errorf("expected a body error got %v", err)
+	}
+}
@@ -24,14 +24,33 @@ func Chain(middleware ...interface{}) *MiddlewareChain {
 
 // End the chain and return the http.Handler
 func (self *MiddlewareChain) Then(handler ContextHandler) http.Handler {
-	for i := len(self.middleware) - 1; i >= 0; i-- {
-		handler = self.middleware[i](handler)
-	}
+	handler = wrapMiddleware(self.middleware, handler)
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		handler.ServeHTTP(context.Background(), w, req)
 	})
 }
 
+// Wrap the handler in the middleware, the first middleware is called first
+func wrapMiddleware(middleware []Middleware, handler ContextHandler) ContextHandler {
+	handler = abortable(handler)
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = abortable(middleware[i](handler))
+	}
+	return handler
+}
+
+// Wrap the handler such that a call to Abort() only stops this handler, the
+// middleware that called it continues as if the handler returned normally
+func abortable(handler ContextHandler) ContextHandler {
+	if handler == nil {
+		return nil
+	}
+	return ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+		defer recoverAbort()
+		handler.ServeHTTP(ctx, w, req)
+	})
+}
+
 // Same as Then(), but accepts a ContextHandlerFunc
 func (self *MiddlewareChain) ThenFunc(handlerFunc ContextHandlerFunc) http.Handler {
 	if handlerFunc == nil {
@@ -70,6 +89,9 @@ func appendMiddleware(dest []Middleware, middleware ...interface{}) []Middleware
 		// Normal Middleware
 		case Middleware:
 			dest = append(dest, t)
+		// Method values such as cache.Handler
+		case func(ContextHandler) ContextHandler:
+			dest = append(dest, t)
 		// Handle http.Handler middleware
 		case http.Handler:
 			// NOTE: http.Handler middleware can not catch panic's as they are not in the chain,
@@ -0,0 +1,364 @@
+package canis
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	ContentTypeCsv      = "text/csv; charset=utf-8"
+	ContentTypeMsgPack  = "application/msgpack"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// Returned by Negotiate() when none of the registered encoders satisfy the
+// 'Accept' header of the request
+var ErrNotAcceptable = errors.New("no acceptable content type for the request")
+
+// Returned by an Encoder when asked to encode a value it does not support
+var ErrUnsupportedValue = errors.New("value not supported by the encoder")
+
+// Encoder writes a value to the response in a specific media type
+type Encoder struct {
+	// The Content-Type header sent with the response, e.g. 'application/json; charset=utf-8'
+	ContentType string
+	// Returns true if the encoder is able to encode the value, if nil all values are accepted
+	CanEncode func(value interface{}) bool
+	// Encode the value to the writer
+	Encode func(writer io.Writer, value interface{}) error
+}
+
+type registeredEncoder struct {
+	mediaType string
+	encoder   Encoder
+}
+
+// The media type with the parameters of the Content-Type the encoder sends, so
+// ranges such as 'text/csv; charset=utf-8' are matched against them
+func (self registeredEncoder) offer() string {
+	fields := strings.SplitN(self.encoder.ContentType, ";", 2)
+	if len(fields) == 2 {
+		if kind, subtype := splitMediaType(fields[0]); kind+"/"+subtype == self.mediaType {
+			return self.encoder.ContentType
+		}
+	}
+	return self.mediaType
+}
+
+var encoders struct {
+	sync.RWMutex
+	list []registeredEncoder
+}
+
+// Register an Encoder for the media type (e.g. 'application/json'). Registering a
+// media type that already exists replaces the existing encoder. When a client
+// accepts several media types with the same quality, the encoder registered
+// first wins.
+func RegisterEncoder(mediaType string, encoder Encoder) {
+	mediaType = strings.ToLower(mediaType)
+
+	encoders.Lock()
+	defer encoders.Unlock()
+	for i := range encoders.list {
+		if encoders.list[i].mediaType == mediaType {
+			encoders.list[i].encoder = encoder
+			return
+		}
+	}
+	encoders.list = append(encoders.list, registeredEncoder{mediaType, encoder})
+}
+
+// Remove the Encoder for the media type
+func UnregisterEncoder(mediaType string) {
+	mediaType = strings.ToLower(mediaType)
+
+	encoders.Lock()
+	defer encoders.Unlock()
+	for i := range encoders.list {
+		if encoders.list[i].mediaType == mediaType {
+			encoders.list = append(encoders.list[:i], encoders.list[i+1:]...)
+			return
+		}
+	}
+}
+
+// Values that can be encoded by the 'application/msgpack' encoder. This is
+// the interface implemented by code generated with github.com/tinylib/msgp
+type MsgPackMarshaler interface {
+	MarshalMsg([]byte) ([]byte, error)
+}
+
+// Values that can be encoded by the 'application/x-protobuf' encoder. This is
+// the interface implemented by gogo/protobuf generated messages
+type ProtobufMarshaler interface {
+	Marshal() ([]byte, error)
+}
+
+// Values that can be encoded by the 'text/csv' encoder, [][]string is also accepted
+type CsvMarshaler interface {
+	MarshalCSV() ([][]string, error)
+}
+
+func init() {
+	RegisterEncoder("application/json", Encoder{
+		ContentType: ContentTypeJson,
+		Encode: func(writer io.Writer, value interface{}) error {
+			return json.NewEncoder(writer).Encode(value)
+		},
+	})
+	RegisterEncoder("application/xml", Encoder{
+		ContentType: ContentTypeXml,
+		Encode: func(writer io.Writer, value interface{}) error {
+			if _, err := io.WriteString(writer, xml.Header); err != nil {
+				return err
+			}
+			return xml.NewEncoder(writer).Encode(value)
+		},
+	})
+	RegisterEncoder("application/msgpack", Encoder{
+		ContentType: ContentTypeMsgPack,
+		CanEncode: func(value interface{}) bool {
+			_, ok := value.(MsgPackMarshaler)
+			return ok
+		},
+		Encode: func(writer io.Writer, value interface{}) error {
+			marshaler, ok := value.(MsgPackMarshaler)
+			if !ok {
+				return ErrUnsupportedValue
+			}
+			buf, err := marshaler.MarshalMsg(nil)
+			if err != nil {
+				return err
+			}
+			_, err = writer.Write(buf)
+			return err
+		},
+	})
+	RegisterEncoder("application/x-protobuf", Encoder{
+		ContentType: ContentTypeProtobuf,
+		CanEncode: func(value interface{}) bool {
+			_, ok := value.(ProtobufMarshaler)
+			return ok
+		},
+		Encode: func(writer io.Writer, value interface{}) error {
+			marshaler, ok := value.(ProtobufMarshaler)
+			if !ok {
+				return ErrUnsupportedValue
+			}
+			buf, err := marshaler.Marshal()
+			if err != nil {
+				return err
+			}
+			_, err = writer.Write(buf)
+			return err
+		},
+	})
+	RegisterEncoder("text/csv", Encoder{
+		ContentType: ContentTypeCsv,
+		CanEncode: func(value interface{}) bool {
+			switch value.(type) {
+			case CsvMarshaler, [][]string:
+				return true
+			}
+			return false
+		},
+		Encode: func(writer io.Writer, value interface{}) error {
+			var records [][]string
+			switch t := value.(type) {
+			case CsvMarshaler:
+				var err error
+				if records, err = t.MarshalCSV(); err != nil {
+					return err
+				}
+			case [][]string:
+				records = t
+			default:
+				return ErrUnsupportedValue
+			}
+			return csv.NewWriter(writer).WriteAll(records)
+		},
+	})
+}
+
+// A single media range from an 'Accept' header
+type AcceptRange struct {
+	Type    string
+	Subtype string
+	Params  map[string]string
+	Quality float64
+}
+
+// Returns true if the media type 'type/subtype', optionally with parameters
+// such as 'text/html; charset=utf-8', is matched by this range. The media type
+// must have every parameter of the range with the same value, parameters the
+// range does not specify are ignored; 'text/html;level=1' matches
+// 'text/html; level=1; charset=utf-8' but not 'text/html' or 'text/html; level=2'
+func (self AcceptRange) Match(mediaType string) bool {
+	fields := strings.Split(mediaType, ";")
+	kind, subtype := splitMediaType(fields[0])
+	if self.Type != "*" && self.Type != kind {
+		return false
+	}
+	if self.Subtype != "*" && self.Subtype != subtype {
+		return false
+	}
+	matched := 0
+	for _, param := range fields[1:] {
+		key, value, ok := splitParam(param)
+		if !ok {
+			continue
+		}
+		if expected, ok := self.Params[key]; ok {
+			if !strings.EqualFold(expected, value) {
+				return false
+			}
+			matched++
+		}
+	}
+	return matched == len(self.Params)
+}
+
+// Higher values are more specific; 'text/html' > 'text/*' > '*/*'
+func (self AcceptRange) specificity() int {
+	switch {
+	case self.Type == "*":
+		return 0
+	case self.Subtype == "*":
+		return 1
+	}
+	return 2 + len(self.Params)
+}
+
+// Parse an 'Accept' header into a list of media ranges ordered by quality,
+// ranges of equal quality are ordered by specificity. Malformed ranges are
+// skipped, as are the extension parameters after the quality.
+func ParseAccept(header string) []AcceptRange {
+	var result []AcceptRange
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		kind, subtype := splitMediaType(fields[0])
+		if kind == "" || subtype == "" || (kind == "*" && subtype != "*") {
+			continue
+		}
+
+		accept := AcceptRange{Type: kind, Subtype: subtype, Quality: 1}
+		for _, param := range fields[1:] {
+			key, value, ok := splitParam(param)
+			if !ok {
+				continue
+			}
+			if key == "q" {
+				q, err := strconv.ParseFloat(value, 64)
+				if err != nil || q < 0 || q > 1 {
+					q = 0
+				}
+				accept.Quality = q
+				break
+			}
+			if accept.Params == nil {
+				accept.Params = make(map[string]string)
+			}
+			accept.Params[key] = value
+		}
+		result = append(result, accept)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Quality != result[j].Quality {
+			return result[i].Quality > result[j].Quality
+		}
+		return result[i].specificity() > result[j].specificity()
+	})
+	return result
+}
+
+// Returns the lower case key and unquoted value of a 'key=value' parameter
+func splitParam(param string) (string, string, bool) {
+	pos := strings.IndexByte(param, '=')
+	if pos == -1 {
+		return "", "", false
+	}
+	return strings.ToLower(strings.TrimSpace(param[:pos])), strings.Trim(strings.TrimSpace(param[pos+1:]), `"`), true
+}
+
+func splitMediaType(mediaType string) (string, string) {
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	pos := strings.IndexByte(mediaType, '/')
+	if pos == -1 {
+		return "", ""
+	}
+	return strings.TrimSpace(mediaType[:pos]), strings.TrimSpace(mediaType[pos+1:])
+}
+
+// Returns the quality the client assigned to the media type, the most
+// specific matching range decides. Returns 0 if the media type is not acceptable
+func quality(ranges []AcceptRange, mediaType string) float64 {
+	best := -1
+	var q float64
+	for _, accept := range ranges {
+		if accept.Match(mediaType) && accept.specificity() > best {
+			best = accept.specificity()
+			q = accept.Quality
+		}
+	}
+	return q
+}
+
+// Choose the media type and encoder for the value that best satisfies the
+// 'Accept' header. An empty header accepts anything.
+func Acceptable(accept string, value interface{}) (string, Encoder, bool) {
+	if strings.TrimSpace(accept) == "" {
+		accept = "*/*"
+	}
+	ranges := ParseAccept(accept)
+
+	// Copy the list so CanEncode() is called without the lock held, it may register encoders
+	encoders.RLock()
+	list := append([]registeredEncoder(nil), encoders.list...)
+	encoders.RUnlock()
+
+	var best registeredEncoder
+	var bestQ float64
+	for _, item := range list {
+		if item.encoder.CanEncode != nil && !item.encoder.CanEncode(value) {
+			continue
+		}
+		if q := quality(ranges, item.offer()); q > bestQ {
+			best, bestQ = item, q
+		}
+	}
+	if bestQ == 0 {
+		return "", Encoder{}, false
+	}
+	return best.mediaType, best.encoder, true
+}
+
+// Encode the value with a 200 status code using the registered encoder that
+// best matches the 'Accept' header of the request
+func Negotiate(resp http.ResponseWriter, req *http.Request, value interface{}) error {
+	return NegotiateStatus(resp, req, http.StatusOK, value)
+}
+
+// Same as Negotiate() but with the provided status code. If no encoder
+// satisfies the request a 406 is written using Error() and ErrNotAcceptable is returned.
+func NegotiateStatus(resp http.ResponseWriter, req *http.Request, status int, value interface{}) error {
+	resp.Header().Add("Vary", "Accept")
+
+	_, encoder, ok := Acceptable(req.Header.Get("Accept"), value)
+	if !ok {
+		Error(resp, http.StatusText(http.StatusNotAcceptable), http.StatusNotAcceptable)
+		return ErrNotAcceptable
+	}
+
+	resp.Header().Set("Content-Type", encoder.ContentType)
+	resp.WriteHeader(status)
+	return encoder.Encode(resp, value)
+}
@@ -0,0 +1,116 @@
+package canis
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type protoPie struct{}
+
+func (protoPie) Marshal() ([]byte, error) {
+	return []byte{0x0a, 0x05}, nil
+}
+
+func TestParseAccept(t *testing.T) {
+	ranges := ParseAccept("text/*;q=0.3, text/html;q=0.7, text/html;level=1, bogus, */*;q=0.5;ext=1")
+	expected := []string{"text/html", "text/html", "*/*", "text/*"}
+	if len(ranges) != len(expected) {
+		t.Fatalf("expected %d ranges got %d: %+v", len(expected), len(ranges), ranges)
+	}
+	for i, accept := range ranges {
+		if accept.Type+"/"+accept.Subtype != expected[i] {
+			t.Errorf("range %d: expected %s got %s/%s", i, expected[i], accept.Type, accept.Subtype)
+		}
+	}
+	if ranges[0].Params["level"] != "1" || ranges[0].Quality != 1 {
+		t.Errorf("wrong first range %+v", ranges[0])
+	}
+	if ranges[2].Params != nil || ranges[2].Quality != 0.5 {
+		t.Errorf("expected no params after 'q' got %+v", ranges[2])
+	}
+
+	tests := []struct {
+		mediaType string
+		quality   float64
+	}{
+		{"text/html", 0.7},
+		{"text/html; level=1", 1},
+		{"text/html; charset=utf-8; level=1", 1},
+		{"text/html; level=2", 0.7},
+		{"text/plain", 0.3},
+		{"image/jpeg", 0.5},
+	}
+	for _, test := range tests {
+		if q := quality(ranges, test.mediaType); q != test.quality {
+			t.Errorf("%s: expected quality %v got %v", test.mediaType, test.quality, q)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept      string
+		value       interface{}
+		code        int
+		contentType string
+	}{
+		{"", pie{"apple"}, 200, ContentTypeJson},
+		{"*/*", pie{"apple"}, 200, ContentTypeJson},
+		{"application/xml, application/json;q=0.9", pie{"apple"}, 200, ContentTypeXml},
+		{"application/*;q=0.2, text/csv", [][]string{{"apple"}}, 200, ContentTypeCsv},
+		{"application/x-protobuf, */*;q=0.1", protoPie{}, 200, ContentTypeProtobuf},
+		{"application/x-protobuf, */*;q=0.1", pie{"apple"}, 200, ContentTypeJson},
+		{"application/json;q=0, */*", pie{"apple"}, 200, ContentTypeXml},
+		{"application/json; charset=utf-8", pie{"apple"}, 200, ContentTypeJson},
+		{"application/json; charset=iso-8859-1, application/xml;q=0.5", pie{"apple"}, 200, ContentTypeXml},
+		{"text/csv", pie{"apple"}, 406, "text/plain; charset=utf-8"},
+		{"image/png", pie{"apple"}, 406, "text/plain; charset=utf-8"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", test.accept)
+		err := Negotiate(w, req, test.value)
+
+		if w.Code != test.code {
+			t.Errorf("%q: expected code %d got %d", test.accept, test.code, w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != test.contentType {
+			t.Errorf("%q: expected Content-Type %q got %q", test.accept, test.contentType, ct)
+		}
+		if test.code == 406 && err != ErrNotAcceptable {
+			t.Errorf("%q: expected ErrNotAcceptable got %v", test.accept, err)
+		}
+		if w.Header().Get("Vary") != "Accept" {
+			t.Errorf("%q: missing 'Vary: Accept'", test.accept)
+		}
+	}
+}
+
+func TestAcceptableRegisterFromCanEncode(t *testing.T) {
+	defer UnregisterEncoder("application/x-pie")
+	defer UnregisterEncoder("application/x-tart")
+	RegisterEncoder("application/x-pie", Encoder{
+		ContentType: "application/x-pie",
+		CanEncode: func(value interface{}) bool {
+			RegisterEncoder("application/x-tart", Encoder{ContentType: "application/x-tart"})
+			return true
+		},
+	})
+
+	done := make(chan string)
+	go func() {
+		mediaType, _, _ := Acceptable("application/x-pie", pie{"apple"})
+		done <- mediaType
+	}()
+	select {
+	case mediaType := <-done:
+		if mediaType != "application/x-pie" {
+			t.Errorf("expected 'application/x-pie' got '%s'", mediaType)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Acceptable() deadlocked calling CanEncode")
+	}
+}
@@ -0,0 +1,191 @@
+package canis
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Returned (wrapped in a *ParamError) when the route has no param with the requested name
+var ErrParamMissing = errors.New("param is missing")
+
+// Returned by the typed accessors when a param is missing or can not be converted
+type ParamError struct {
+	Name  string
+	Value string
+	Err   error
+}
+
+func (self *ParamError) Error() string {
+	if self.Err == ErrParamMissing {
+		return "param '" + self.Name + "' is missing"
+	}
+	return "param '" + self.Name + "' with value '" + self.Value + "'; " + self.Err.Error()
+}
+
+// Lookup returns the value of the first Param which key matches the given name
+// and true, or an empty string and false if no such Param exists.
+func (ps Params) Lookup(name string) (string, bool) {
+	for i := range ps {
+		if ps[i].Key == name {
+			return ps[i].Value, true
+		}
+	}
+	return "", false
+}
+
+// Raw returns the value as it appears in the escaped path of the request,
+// such as 'apple%20pie' for 'apple pie', including the leading '/' of
+// catch-all params. Use the Raw() of the ParamContext given to the handle to
+// keep escapes the client sent that did not need escaping, such as '%2F'.
+func (ps Params) Raw(name string) (string, error) {
+	value, ok := ps.Lookup(name)
+	if !ok {
+		return "", &ParamError{name, "", ErrParamMissing}
+	}
+	return (&url.URL{Path: value}).EscapedPath(), nil
+}
+
+// Unescaped returns the value with any %XX escapes decoded, the same value as
+// ByName() but with an error if the param is missing
+func (ps Params) Unescaped(name string) (string, error) {
+	value, ok := ps.Lookup(name)
+	if !ok {
+		return "", &ParamError{name, "", ErrParamMissing}
+	}
+	return value, nil
+}
+
+type rawParamsKey struct{}
+
+// Raw returns the value as it appears in the escaped path of the request, see Params.Raw()
+func (self ParamContextImpl) Raw(name string) (string, error) {
+	if self.Context != nil {
+		if raw, ok := self.Context.Value(rawParamsKey{}).(Params); ok {
+			if value, ok := raw.Lookup(name); ok {
+				return value, nil
+			}
+		}
+	}
+	return self.Params.Raw(name)
+}
+
+// Returns a copy of the context with the params as they appear in the escaped
+// path, if the request escaped characters that did not need escaping. Otherwise
+// escaping the params again gives the same values and nothing is recorded.
+func withRawParams(ctx context.Context, root *node, req *http.Request, ps Params) context.Context {
+	if len(ps) == 0 || req.URL.RawPath == "" {
+		return ctx
+	}
+	_, raw, _ := root.getValue(req.URL.EscapedPath(), nil)
+	if len(raw) != len(ps) {
+		return ctx
+	}
+	for i := range raw {
+		if raw[i].Key != ps[i].Key {
+			return ctx
+		}
+	}
+	return context.WithValue(ctx, rawParamsKey{}, raw)
+}
+
+// Returns the value for conversion by the typed accessors. Catch-all params
+// are the only params that can begin with '/', which is removed so '/files/*id'
+// with '/files/10' converts the same as ':id' would
+func (ps Params) typed(name string) (string, error) {
+	value, err := ps.Unescaped(name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(value, "/"), nil
+}
+
+// Int returns the value of the param as an int
+func (ps Params) Int(name string) (int, error) {
+	value, err := ps.typed(name)
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, &ParamError{name, value, errors.New("not a valid integer")}
+	}
+	return i, nil
+}
+
+// Int64 returns the value of the param as an int64
+func (ps Params) Int64(name string) (int64, error) {
+	value, err := ps.typed(name)
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, &ParamError{name, value, errors.New("not a valid 64 bit integer")}
+	}
+	return i, nil
+}
+
+// Bool returns the value of the param as a bool, accepts the same values as strconv.ParseBool()
+func (ps Params) Bool(name string) (bool, error) {
+	value, err := ps.typed(name)
+	if err != nil {
+		return false, err
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, &ParamError{name, value, errors.New("not a valid boolean")}
+	}
+	return b, nil
+}
+
+// UUID returns the value of the param in the canonical lower case
+// 'xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx' form if it is a valid UUID
+func (ps Params) UUID(name string) (string, error) {
+	value, err := ps.typed(name)
+	if err != nil {
+		return "", err
+	}
+	if !isUUID(value) {
+		return "", &ParamError{name, value, errors.New("not a valid UUID")}
+	}
+	return strings.ToLower(value), nil
+}
+
+// Time returns the value of the param parsed with the layout, see time.Parse()
+func (ps Params) Time(name, layout string) (time.Time, error) {
+	value, err := ps.typed(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := time.Parse(layout, value)
+	if err != nil {
+		return time.Time{}, &ParamError{name, value, errors.New("not a valid time with layout '" + layout + "'")}
+	}
+	return t, nil
+}
+
+func isUUID(value string) bool {
+	if len(value) != 36 {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		switch i {
+		case 8, 13, 18, 23:
+			if value[i] != '-' {
+				return false
+			}
+			continue
+		}
+		c := value[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
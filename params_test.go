@@ -0,0 +1,118 @@
+package canis
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestParamsLookup(t *testing.T) {
+	ps := Params{Param{"empty", ""}, Param{"name", "gopher"}}
+
+	if value, ok := ps.Lookup("empty"); !ok || value != "" {
+		t.Errorf("expected empty param to be found; got %q, %v", value, ok)
+	}
+	if _, ok := ps.Lookup("missing"); ok {
+		t.Error("expected missing param to not be found")
+	}
+
+	_, err := ps.Int("missing")
+	if paramErr, ok := err.(*ParamError); !ok || paramErr.Err != ErrParamMissing {
+		t.Errorf("expected ErrParamMissing got %v", err)
+	}
+}
+
+func TestParamsTyped(t *testing.T) {
+	ps := Params{
+		Param{"id", "42"},
+		Param{"big", "9000000000"},
+		Param{"flag", "true"},
+		Param{"uuid", "3F2504E0-4F89-11D3-9A0C-0305E82C3301"},
+		Param{"date", "2016-01-02"},
+		Param{"spaced", "apple pie"},
+		Param{"bad", "pie"},
+	}
+
+	if i, err := ps.Int("id"); err != nil || i != 42 {
+		t.Errorf("Int(): got %d, %v", i, err)
+	}
+	if i, err := ps.Int64("big"); err != nil || i != 9000000000 {
+		t.Errorf("Int64(): got %d, %v", i, err)
+	}
+	if b, err := ps.Bool("flag"); err != nil || !b {
+		t.Errorf("Bool(): got %v, %v", b, err)
+	}
+	if u, err := ps.UUID("uuid"); err != nil || u != "3f2504e0-4f89-11d3-9a0c-0305e82c3301" {
+		t.Errorf("UUID(): got %q, %v", u, err)
+	}
+	if d, err := ps.Time("date", "2006-01-02"); err != nil || !d.Equal(time.Date(2016, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Time(): got %v, %v", d, err)
+	}
+	if s, err := ps.Unescaped("spaced"); err != nil || s != "apple pie" {
+		t.Errorf("Unescaped(): got %q, %v", s, err)
+	}
+	if s, err := ps.Raw("spaced"); err != nil || s != "apple%20pie" {
+		t.Errorf("Raw(): got %q, %v", s, err)
+	}
+
+	if _, err := ps.Int("bad"); err == nil {
+		t.Error("Int(): expected an error for 'pie'")
+	}
+	if _, err := ps.Bool("bad"); err == nil {
+		t.Error("Bool(): expected an error for 'pie'")
+	}
+	if _, err := ps.UUID("bad"); err == nil {
+		t.Error("UUID(): expected an error for 'pie'")
+	}
+}
+
+func TestParamsCatchAll(t *testing.T) {
+	router := NewRouter()
+
+	var id int
+	var raw string
+	router.GET("/pies/*id", func(ctx ParamContext, _ http.ResponseWriter, _ *http.Request) {
+		id, _ = ctx.Int("id")
+		raw, _ = ctx.Raw("id")
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/pies/12", nil)
+	router.ServeHTTP(context.Background(), w, req)
+
+	if id != 12 || raw != "/12" {
+		t.Errorf("wrong catch-all values; got %d, %q", id, raw)
+	}
+}
+
+func TestParamsEscaped(t *testing.T) {
+	router := NewRouter()
+
+	var raw, unescaped string
+	handle := func(ctx ParamContext, _ http.ResponseWriter, _ *http.Request) {
+		raw, _ = ctx.Raw("name")
+		unescaped, _ = ctx.Unescaped("name")
+	}
+	router.GET("/users/:name", handle)
+	router.GET("/files/*name", handle)
+
+	tests := []struct {
+		path, raw, unescaped string
+	}{
+		{"/users/100%25", "100%25", "100%"},
+		{"/users/caf%C3%A9", "caf%C3%A9", "café"},
+		{"/users/%41pple", "%41pple", "Apple"},
+		{"/files/a%2Fb/c", "/a%2Fb/c", "/a/b/c"},
+	}
+	for _, test := range tests {
+		raw, unescaped = "", ""
+		req, _ := http.NewRequest("GET", test.path, nil)
+		router.ServeHTTP(context.Background(), httptest.NewRecorder(), req)
+		if raw != test.raw || unescaped != test.unescaped {
+			t.Errorf("%s: expected %q and %q got %q and %q", test.path, test.raw, test.unescaped, raw, unescaped)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package canis
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"regexp"
+)
+
+const (
+	ContentTypeJson       = "application/json; charset=utf-8"
+	ContentTypeJavascript = "application/javascript; charset=utf-8"
+	ContentTypeXml        = "application/xml; charset=utf-8"
+	ContentTypeText       = "text/plain; charset=utf-8"
+)
+
+// Only callbacks that look like javascript identifiers (optionally dotted, as in
+// 'jQuery.cb_123') are accepted for JSONP, anything else could inject script
+var validCallback = regexp.MustCompile(`^[a-zA-Z_$][a-zA-Z0-9_$]*(\.[a-zA-Z_$][a-zA-Z0-9_$]*)*$`)
+
+// abortSignal is the value Abort() panics with, it is recovered by the
+// MiddlewareChain and the Router so the rest of the handler never runs
+type abortSignal struct{}
+
+// ErrorRenderer writes the body of every error produced by canis; Abort(),
+// Error() and the Router's default 404 and 405 responses all go through it.
+// Replace it to change the error format for every handler and middleware.
+var ErrorRenderer = func(resp http.ResponseWriter, msg string, code int) {
+	http.Error(resp, msg, code)
+}
+
+// Write the error message and status code using the ErrorRenderer
+func Error(resp http.ResponseWriter, msg string, code int) {
+	ErrorRenderer(resp, msg, code)
+}
+
+// Write the error message and status code using the ErrorRenderer then stop
+// the current handler; no code after Abort() is run and the middleware that
+// called the aborted handler resumes as if it had returned normally
+func Abort(resp http.ResponseWriter, msg string, code int) {
+	Error(resp, msg, code)
+	panic(abortSignal{})
+}
+
+// Returns true if the value recovered from a panic is the signal sent by
+// Abort(), middleware that recover panics should pass it on after cleaning up
+func IsAbort(rcv interface{}) bool {
+	_, ok := rcv.(abortSignal)
+	return ok
+}
+
+// Recover an Abort() signal, any other panic is passed on
+func recoverAbort() {
+	if rcv := recover(); rcv != nil {
+		if _, ok := rcv.(abortSignal); !ok {
+			panic(rcv)
+		}
+	}
+}
+
+// Encode the value as JSON and write it with a 200 status code
+func ToJson(resp http.ResponseWriter, value interface{}) error {
+	return ToJsonStatus(resp, http.StatusOK, value)
+}
+
+// Encode the value as JSON and write it with the provided status code. The
+// value is encoded into a buffer before anything is written, so if it can not
+// be encoded a 500 is sent with Error() and the encoding error is returned.
+func ToJsonStatus(resp http.ResponseWriter, status int, value interface{}) error {
+	return writeJson(resp, status, value, false)
+}
+
+// Same as ToJsonStatus() but honors the '?pretty' query parameter to indent the
+// output and the '?callback' query parameter to wrap the output for JSONP
+func RenderJson(resp http.ResponseWriter, req *http.Request, status int, value interface{}) error {
+	query := req.URL.Query()
+	pretty := isPretty(req)
+
+	callback := query.Get("callback")
+	if callback == "" {
+		return writeJson(resp, status, value, pretty)
+	}
+	if !validCallback.MatchString(callback) {
+		Error(resp, "invalid JSONP callback", http.StatusBadRequest)
+		return nil
+	}
+
+	var buf bytes.Buffer
+	// The leading comment protects against the Rosetta Flash attack
+	buf.WriteString("/**/" + callback + "(")
+	if err := encodeJson(&buf, value, pretty); err != nil {
+		Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return err
+	}
+	buf.WriteString(");")
+
+	resp.Header().Set("Content-Type", ContentTypeJavascript)
+	resp.Header().Set("X-Content-Type-Options", "nosniff")
+	resp.WriteHeader(status)
+	_, err := resp.Write(buf.Bytes())
+	return err
+}
+
+// Encode the value as XML and write it with a 200 status code
+func ToXml(resp http.ResponseWriter, value interface{}) error {
+	return ToXmlStatus(resp, http.StatusOK, value)
+}
+
+// Encode the value as XML and write it with the provided status code. Like
+// ToJsonStatus() a 500 is sent if the value can not be encoded
+func ToXmlStatus(resp http.ResponseWriter, status int, value interface{}) error {
+	return writeXml(resp, status, value, false)
+}
+
+// Same as ToXmlStatus() but honors the '?pretty' query parameter to indent the output
+func RenderXml(resp http.ResponseWriter, req *http.Request, status int, value interface{}) error {
+	return writeXml(resp, status, value, isPretty(req))
+}
+
+func writeJson(resp http.ResponseWriter, status int, value interface{}, pretty bool) error {
+	var buf bytes.Buffer
+	if err := encodeJson(&buf, value, pretty); err != nil {
+		Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return err
+	}
+	resp.Header().Set("Content-Type", ContentTypeJson)
+	resp.WriteHeader(status)
+	_, err := resp.Write(buf.Bytes())
+	return err
+}
+
+func encodeJson(writer io.Writer, value interface{}, pretty bool) error {
+	encoder := json.NewEncoder(writer)
+	if pretty {
+		encoder.SetIndent("", "  ")
+	}
+	return encoder.Encode(value)
+}
+
+func writeXml(resp http.ResponseWriter, status int, value interface{}, pretty bool) error {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	encoder := xml.NewEncoder(&buf)
+	if pretty {
+		encoder.Indent("", "  ")
+	}
+	if err := encoder.Encode(value); err != nil {
+		Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return err
+	}
+	resp.Header().Set("Content-Type", ContentTypeXml)
+	resp.WriteHeader(status)
+	_, err := resp.Write(buf.Bytes())
+	return err
+}
+
+// Returns true if the request asked for indented output with '?pretty', '?pretty=true' or '?pretty=1'
+func isPretty(req *http.Request) bool {
+	values, ok := req.URL.Query()["pretty"]
+	if !ok {
+		return false
+	}
+	switch values[0] {
+	case "", "1", "true":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package canis
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+type pie struct {
+	Name string `json:"name" xml:"name"`
+}
+
+func TestToJson(t *testing.T) {
+	w := httptest.NewRecorder()
+	if err := ToJson(w, pie{"apple"}); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != 200 {
+		t.Errorf("expected code 200 got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != ContentTypeJson {
+		t.Errorf("wrong Content-Type %q", ct)
+	}
+	if w.Body.String() != "{\"name\":\"apple\"}\n" {
+		t.Errorf("wrong body %q", w.Body.String())
+	}
+}
+
+func TestRenderJson(t *testing.T) {
+	tests := []struct {
+		url  string
+		code int
+		body string
+	}{
+		{"/", 201, "{\"name\":\"apple\"}\n"},
+		{"/?pretty", 201, "{\n  \"name\": \"apple\"\n}\n"},
+		{"/?pretty=false", 201, "{\"name\":\"apple\"}\n"},
+		{"/?callback=jQuery.cb_1", 201, "/**/jQuery.cb_1({\"name\":\"apple\"}\n);"},
+		{"/?callback=alert(1)", 400, "invalid JSONP callback\n"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.url, nil)
+		if err := RenderJson(w, req, 201, pie{"apple"}); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != test.code {
+			t.Errorf("%s: expected code %d got %d", test.url, test.code, w.Code)
+		}
+		if w.Body.String() != test.body {
+			t.Errorf("%s: wrong body %q", test.url, w.Body.String())
+		}
+	}
+}
+
+func TestToXml(t *testing.T) {
+	w := httptest.NewRecorder()
+	if err := ToXmlStatus(w, 202, pie{"apple"}); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != 202 {
+		t.Errorf("expected code 202 got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != ContentTypeXml {
+		t.Errorf("wrong Content-Type %q", ct)
+	}
+	expected := "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<pie><name>apple</name></pie>"
+	if w.Body.String() != expected {
+		t.Errorf("wrong body %q", w.Body.String())
+	}
+}
+
+func TestToJsonError(t *testing.T) {
+	w := httptest.NewRecorder()
+	if err := ToJsonStatus(w, 201, map[string]interface{}{"pie": make(chan int)}); err == nil {
+		t.Fatal("expected an encoding error")
+	}
+	if w.Code != 500 || w.Body.String() != "Internal Server Error\n" {
+		t.Errorf("wrong response %d %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/?callback=cb", nil)
+	if err := RenderJson(w, req, 201, make(chan int)); err == nil {
+		t.Fatal("expected an encoding error")
+	}
+	if w.Code != 500 || w.Header().Get("Content-Type") == ContentTypeJavascript {
+		t.Errorf("wrong response %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+}
+
+func TestAbort(t *testing.T) {
+	var after bool
+	var resumed bool
+	middleware := func(next ContextHandler) ContextHandler {
+		return ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+			next.ServeHTTP(ctx, w, req)
+			resumed = true
+		})
+	}
+	handler := Chain(Middleware(middleware)).ThenFunc(func(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+		Abort(w, "not allowed", 403)
+		after = true
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	handler.ServeHTTP(w, req)
+
+	if after {
+		t.Error("handler continued after Abort()")
+	}
+	if !resumed {
+		t.Error("middleware did not resume after Abort()")
+	}
+	if w.Code != 403 || w.Body.String() != "not allowed\n" {
+		t.Errorf("wrong response %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestRouterAbort(t *testing.T) {
+	router := NewRouter()
+	router.GET("/pie", func(_ ParamContext, w http.ResponseWriter, _ *http.Request) {
+		Abort(w, "gone", 410)
+		t.Error("handler continued after Abort()")
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/pie", nil)
+	router.ServeHTTP(context.Background(), w, req)
+
+	if w.Code != 410 {
+		t.Errorf("expected code 410 got %d", w.Code)
+	}
+}
@@ -0,0 +1,233 @@
+package request
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/thrawn01/canis"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/net/context"
+)
+
+// The header API keys are usually sent in, see APIKey()
+const APIKeyHeader = "X-Api-Key"
+
+// An authenticated client
+type Principal struct {
+	// The user name, or the name given to an API key
+	ID string
+	// How the client authenticated; 'basic', 'apikey' or 'jwt'
+	Method string
+	// The roles and scopes granted to the principal, checked by Authorize()
+	Roles  []string
+	Scopes []string
+}
+
+type principalKey struct{}
+
+// Returns the principal authenticated by BasicAuth(), APIKey() or JWT(), or nil if the request is not authenticated
+func GetPrincipal(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// Returns a copy of the context with the authenticated principal, for use by
+// authentication middleware. The access loggers log the ID of the principal as
+// the user if they come before the middleware in the chain.
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	record, _ := ctx.Value(principalRecordKey{}).(*principalRecord)
+	for ; record != nil; record = record.parent {
+		record.principal = principal
+	}
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// Allows the access loggers, which run before authentication, to learn who the principal is once the request completes
+type principalRecord struct {
+	principal *Principal
+	// The record of middleware earlier in the chain, such as the access logger before the cache
+	parent *principalRecord
+}
+
+type principalRecordKey struct{}
+
+func withPrincipalRecord(ctx context.Context) (context.Context, *principalRecord) {
+	parent, _ := ctx.Value(principalRecordKey{}).(*principalRecord)
+	record := &principalRecord{parent: parent}
+	return context.WithValue(ctx, principalRecordKey{}, record), record
+}
+
+// Checks the credentials of a client
+type Verifier interface {
+	// Returns the principal the credentials belong to, or nil if they are not valid. For API keys the
+	// user is empty and the secret is the key. An error means the credentials could not be checked.
+	Verify(ctx context.Context, user, secret string) (*Principal, error)
+}
+
+// Adapts a function to the Verifier interface
+type VerifierFunc func(ctx context.Context, user, secret string) (*Principal, error)
+
+func (self VerifierFunc) Verify(ctx context.Context, user, secret string) (*Principal, error) {
+	return self(ctx, user, secret)
+}
+
+// Returns a Verifier for a map of user names to passwords, for use with BasicAuth()
+func StaticUsers(users map[string]string) Verifier {
+	hashed := make(map[string][sha256.Size]byte, len(users))
+	for user, password := range users {
+		hashed[user] = sha256.Sum256([]byte(password))
+	}
+	return VerifierFunc(func(ctx context.Context, user, secret string) (*Principal, error) {
+		expected, ok := hashed[user]
+		// Compare against nothing for unknown users, so the time taken does not reveal which users exist
+		given := sha256.Sum256([]byte(secret))
+		if subtle.ConstantTimeCompare(expected[:], given[:]) != 1 || !ok {
+			return nil, nil
+		}
+		return &Principal{ID: user}, nil
+	})
+}
+
+// Returns a Verifier for a map of API keys to the names of their owners, for use with APIKey()
+func StaticKeys(keys map[string]string) Verifier {
+	type entry struct {
+		hash [sha256.Size]byte
+		name string
+	}
+	entries := make([]entry, 0, len(keys))
+	for key, name := range keys {
+		entries = append(entries, entry{sha256.Sum256([]byte(key)), name})
+	}
+	return VerifierFunc(func(ctx context.Context, user, secret string) (*Principal, error) {
+		given := sha256.Sum256([]byte(secret))
+		var match *Principal
+		// Compare with every key, so the time taken does not depend on which key matched
+		for _, entry := range entries {
+			if subtle.ConstantTimeCompare(entry.hash[:], given[:]) == 1 {
+				match = &Principal{ID: entry.name}
+			}
+		}
+		return match, nil
+	})
+}
+
+// Returns a Verifier for the users in an htpasswd file, as created by 'htpasswd -B'.
+// Only bcrypt hashes are supported, the file is read once.
+func HtpasswdFile(path string) (Verifier, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	users := make(map[string][]byte)
+	cost := bcrypt.DefaultCost
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		pos := strings.IndexByte(text, ':')
+		if pos == -1 {
+			return nil, fmt.Errorf("%s:%d: expected 'user:hash'", path, line)
+		}
+		hash := text[pos+1:]
+		hashCost, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: unsupported hash for '%s'; only bcrypt is supported", path, line, text[:pos])
+		}
+		if len(users) == 0 || hashCost > cost {
+			cost = hashCost
+		}
+		users[text[:pos]] = []byte(hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Compared against for unknown users, so the time taken does not reveal which
+	// users exist. Made at the largest cost in the file so it takes as long to check
+	unknown, err := bcrypt.GenerateFromPassword([]byte("unknown"), cost)
+	if err != nil {
+		return nil, err
+	}
+	return VerifierFunc(func(ctx context.Context, user, secret string) (*Principal, error) {
+		hash, ok := users[user]
+		if !ok {
+			hash = unknown
+		}
+		err := bcrypt.CompareHashAndPassword(hash, []byte(secret))
+		if err == bcrypt.ErrMismatchedHashAndPassword || !ok {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &Principal{ID: user}, nil
+	}), nil
+}
+
+// Require HTTP Basic authentication. Requests without valid credentials are
+// rejected with 401 (Unauthorized) and a 'WWW-Authenticate' challenge for the
+// realm, or 500 (Internal Server Error) if the verifier fails. The principal is
+// available to later handlers through GetPrincipal().
+func BasicAuth(realm string, verifier Verifier) canis.Middleware {
+	challenge := fmt.Sprintf(`Basic realm="%s", charset="UTF-8"`, strings.Replace(realm, `"`, `\"`, -1))
+	return authenticate("basic", verifier, challenge, func(req *http.Request) (string, string, bool) {
+		return req.BasicAuth()
+	})
+}
+
+// Require an API key in the header, usually APIKeyHeader. Requests without a
+// valid key are rejected with 401 (Unauthorized), or 500 (Internal Server
+// Error) if the verifier fails. The principal is available to later handlers
+// through GetPrincipal().
+func APIKey(header string, verifier Verifier) canis.Middleware {
+	return authenticate("apikey", verifier, "", func(req *http.Request) (string, string, bool) {
+		key := req.Header.Get(header)
+		return "", key, key != ""
+	})
+}
+
+var errUnauthorized = errors.New(http.StatusText(http.StatusUnauthorized))
+
+func authenticate(method string, verifier Verifier, challenge string,
+	credentials func(*http.Request) (string, string, bool)) canis.Middleware {
+
+	return func(next canis.ContextHandler) canis.ContextHandler {
+		return canis.ContextHandlerFunc(func(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+			var principal *Principal
+			err := errUnauthorized
+			if user, secret, ok := credentials(req); ok {
+				principal, err = verifier.Verify(ctx, user, secret)
+				if err == nil && principal == nil {
+					err = errUnauthorized
+				}
+			}
+			if err == errUnauthorized {
+				if challenge != "" {
+					resp.Header().Set("WWW-Authenticate", challenge)
+				}
+				canis.Error(resp, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				canis.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			// The verifier may return a shared principal
+			authenticated := *principal
+			if authenticated.Method == "" {
+				authenticated.Method = method
+			}
+			next.ServeHTTP(WithPrincipal(ctx, &authenticated), resp, req)
+		})
+	}
+}
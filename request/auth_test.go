@@ -0,0 +1,138 @@
+package request_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/thrawn01/canis/request"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("Authentication", func() {
+	var principal *request.Principal
+
+	record := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		principal = request.GetPrincipal(ctx)
+	}
+
+	basic := func(user, password string) *http.Request {
+		req, _ := http.NewRequest("GET", "/pies", nil)
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		return req
+	}
+
+	BeforeEach(func() {
+		principal = nil
+	})
+
+	Describe("BasicAuth()", func() {
+		auth := request.BasicAuth("pies", request.StaticUsers(map[string]string{"joe": "apple"}))
+
+		It("should store the principal of valid credentials", func() {
+			resp := serve(basic("joe", "apple"), record, auth)
+			Expect(resp.Code).To(Equal(http.StatusOK))
+			Expect(principal).To(Equal(&request.Principal{ID: "joe", Method: "basic"}))
+		})
+
+		It("should challenge requests without valid credentials", func() {
+			for _, req := range []*http.Request{basic("", ""), basic("joe", "cherry"), basic("bob", "apple")} {
+				resp := serve(req, record, auth)
+				Expect(resp.Code).To(Equal(http.StatusUnauthorized))
+				Expect(resp.Header().Get("WWW-Authenticate")).To(Equal(`Basic realm="pies", charset="UTF-8"`))
+				Expect(principal).To(BeNil())
+			}
+		})
+
+		It("should fail with 500 if the verifier fails", func() {
+			failing := request.VerifierFunc(func(ctx context.Context, user, secret string) (*request.Principal, error) {
+				return nil, errors.New("database is down")
+			})
+			resp := serve(basic("joe", "apple"), record, request.BasicAuth("pies", failing))
+			Expect(resp.Code).To(Equal(http.StatusInternalServerError))
+			Expect(resp.Body.String()).NotTo(ContainSubstring("database"))
+		})
+
+		It("should verify users in an htpasswd file", func() {
+			hash, _ := bcrypt.GenerateFromPassword([]byte("apple"), bcrypt.MinCost)
+			file, _ := ioutil.TempFile("", "htpasswd")
+			defer os.Remove(file.Name())
+			file.WriteString("# pie makers\njoe:" + string(hash) + "\n")
+			file.Close()
+
+			verifier, err := request.HtpasswdFile(file.Name())
+			Expect(err).NotTo(HaveOccurred())
+			auth := request.BasicAuth("pies", verifier)
+			Expect(serve(basic("joe", "apple"), record, auth).Code).To(Equal(http.StatusOK))
+			Expect(principal.ID).To(Equal("joe"))
+			Expect(serve(basic("joe", "cherry"), record, auth).Code).To(Equal(http.StatusUnauthorized))
+			Expect(serve(basic("bob", "apple"), record, auth).Code).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("should reject htpasswd files with other hashes", func() {
+			file, _ := ioutil.TempFile("", "htpasswd")
+			defer os.Remove(file.Name())
+			file.WriteString("joe:$apr1$salt$hash\n")
+			file.Close()
+
+			_, err := request.HtpasswdFile(file.Name())
+			Expect(err).To(MatchError(ContainSubstring("only bcrypt is supported")))
+		})
+	})
+
+	Describe("APIKey()", func() {
+		auth := request.APIKey(request.APIKeyHeader, request.StaticKeys(map[string]string{
+			"k-123": "billing", "k-456": "reports",
+		}))
+
+		It("should store the principal of a valid key", func() {
+			req, _ := http.NewRequest("GET", "/pies", nil)
+			req.Header.Set("X-Api-Key", "k-456")
+			Expect(serve(req, record, auth).Code).To(Equal(http.StatusOK))
+			Expect(principal).To(Equal(&request.Principal{ID: "reports", Method: "apikey"}))
+		})
+
+		It("should reject missing or invalid keys", func() {
+			req, _ := http.NewRequest("GET", "/pies", nil)
+			resp := serve(req, record, auth)
+			Expect(resp.Code).To(Equal(http.StatusUnauthorized))
+			Expect(resp.Header()).NotTo(HaveKey("WWW-Authenticate"))
+
+			req.Header.Set("X-Api-Key", "k-789")
+			Expect(serve(req, record, auth).Code).To(Equal(http.StatusUnauthorized))
+			Expect(principal).To(BeNil())
+		})
+
+		It("should accept a callback verifier", func() {
+			verifier := request.VerifierFunc(func(ctx context.Context, user, secret string) (*request.Principal, error) {
+				if secret == "letmein" {
+					return &request.Principal{ID: "callback"}, nil
+				}
+				return nil, nil
+			})
+			req, _ := http.NewRequest("GET", "/pies", nil)
+			req.Header.Set("Authorization-Key", "letmein")
+			Expect(serve(req, record, request.APIKey("Authorization-Key", verifier)).Code).To(Equal(http.StatusOK))
+			Expect(principal.ID).To(Equal("callback"))
+		})
+	})
+
+	It("should log the authenticated user", func() {
+		var buf bytes.Buffer
+		auth := request.BasicAuth("pies", request.StaticUsers(map[string]string{"joe": "apple"}))
+		serve(basic("joe", "apple"), record, request.Logger(log.New(&buf, "", 0)), auth)
+		Expect(buf.String()).To(MatchRegexp(`^\S* - joe \[`))
+
+		buf.Reset()
+		serve(basic("", ""), record, request.Logger(log.New(&buf, "", 0)), auth)
+		Expect(buf.String()).To(MatchRegexp(`^\S* - - \[`))
+	})
+})
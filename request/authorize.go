@@ -0,0 +1,175 @@
+package request
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/thrawn01/canis"
+	"golang.org/x/net/context"
+)
+
+// Decides if the principal may make the request, returns why not if it may not.
+// The ParamContext has the params of the matched route when the rule is used
+// as route middleware with canis.Use().
+type Rule func(ctx canis.ParamContext, principal *Principal, req *http.Request) error
+
+// Allow principals with any of the roles
+func HasRole(roles ...string) Rule {
+	return func(ctx canis.ParamContext, principal *Principal, req *http.Request) error {
+		for _, role := range roles {
+			if contains(principal.Roles, role) {
+				return nil
+			}
+		}
+		return errors.New("requires role '" + strings.Join(roles, "' or '") + "'")
+	}
+}
+
+// Allow principals granted all the scopes
+func HasScopes(scopes ...string) Rule {
+	return func(ctx canis.ParamContext, principal *Principal, req *http.Request) error {
+		for _, scope := range scopes {
+			if !contains(principal.Scopes, scope) {
+				return errors.New("requires scope '" + scope + "'")
+			}
+		}
+		return nil
+	}
+}
+
+// Allow the principal whose ID is the value of the route param, such as ':user' in '/v1/users/:user/pies'
+func Owner(param string) Rule {
+	return func(ctx canis.ParamContext, principal *Principal, req *http.Request) error {
+		if value, ok := ctx.Lookup(param); ok && value != "" && value == principal.ID {
+			return nil
+		}
+		return errors.New("not the owner of '" + param + "'")
+	}
+}
+
+// Allow the request if any of the rules allows it
+//
+//	request.AnyOf(request.Owner("user"), request.HasRole("admin"))
+func AnyOf(rules ...Rule) Rule {
+	return func(ctx canis.ParamContext, principal *Principal, req *http.Request) error {
+		reasons := make([]string, 0, len(rules))
+		for _, rule := range rules {
+			err := rule(ctx, principal, req)
+			if err == nil {
+				return nil
+			}
+			reasons = append(reasons, err.Error())
+		}
+		return errors.New(strings.Join(reasons, " or "))
+	}
+}
+
+// A decision made by an Authorizer
+type AuthDecision struct {
+	Principal *Principal
+	Method    string
+	Path      string
+	Allowed   bool
+	// Why the request was denied
+	Reason string
+}
+
+// Called with every decision an Authorizer makes
+type AuditFunc func(ctx context.Context, decision AuthDecision)
+
+// Returns an AuditFunc that logs decisions to the log; denied requests as warnings,
+// allowed requests at the info level
+func AuditLogger(log logrus.FieldLogger) AuditFunc {
+	return func(ctx context.Context, decision AuthDecision) {
+		fields := logrus.Fields{
+			"method":  decision.Method,
+			"path":    decision.Path,
+			"allowed": decision.Allowed,
+		}
+		if decision.Principal != nil {
+			fields["user"] = decision.Principal.ID
+		}
+		if id := GetRequestID(ctx); id != "" {
+			fields["request_id"] = id
+		}
+		if !decision.Allowed {
+			fields["reason"] = decision.Reason
+			log.WithFields(fields).Warn("authorization denied")
+			return
+		}
+		log.WithFields(fields).Info("authorization allowed")
+	}
+}
+
+// Returns an Authorizer that reports its decisions to audit, which may be nil
+//
+//	authz := request.NewAuthorizer(request.AuditLogger(log))
+//	router.DELETE("/v1/pies/:id", deletePie, canis.Use(authz.Require(request.HasRole("admin"))))
+//
+// To require the same rules of a group of routes, register the routes with the same option.
+//
+//	admin := canis.Use(authz.Require(request.HasRole("admin")))
+//	router.POST("/v1/flavors", createFlavor, admin)
+//	router.DELETE("/v1/flavors/:id", deleteFlavor, admin)
+func NewAuthorizer(audit AuditFunc) *Authorizer {
+	return &Authorizer{audit: audit}
+}
+
+type Authorizer struct {
+	audit AuditFunc
+}
+
+// Only allow requests from a principal that all the rules allow. Must come
+// after the authentication middleware, usually as route middleware with
+// canis.Use() so the rules can read the route params. Requests without a
+// principal are rejected with 401 (Unauthorized) and denied requests with 403 (Forbidden).
+func (self *Authorizer) Require(rules ...Rule) canis.Middleware {
+	return func(next canis.ContextHandler) canis.ContextHandler {
+		return canis.ContextHandlerFunc(func(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+			principal := GetPrincipal(ctx)
+			decision := AuthDecision{Principal: principal, Method: req.Method, Path: req.URL.Path, Allowed: true}
+			if principal == nil {
+				decision.Allowed, decision.Reason = false, "not authenticated"
+			} else {
+				pc, ok := ctx.(canis.ParamContext)
+				if !ok {
+					pc = &canis.ParamContextImpl{Context: ctx, Params: canis.ContextParams(ctx)}
+				}
+				for _, rule := range rules {
+					if err := rule(pc, principal, req); err != nil {
+						decision.Allowed, decision.Reason = false, err.Error()
+						break
+					}
+				}
+			}
+
+			if self.audit != nil {
+				self.audit(ctx, decision)
+			}
+			switch {
+			case principal == nil:
+				canis.Error(resp, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			case !decision.Allowed:
+				canis.Error(resp, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			default:
+				next.ServeHTTP(ctx, resp, req)
+			}
+		})
+	}
+}
+
+// Same as Require() of an Authorizer without an audit log
+func Authorize(rules ...Rule) canis.Middleware {
+	return NewAuthorizer(nil).Require(rules...)
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package request_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/Sirupsen/logrus"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/thrawn01/canis"
+	"github.com/thrawn01/canis/request"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("Authorize()", func() {
+	var decisions []request.AuthDecision
+	var router *canis.Router
+
+	users := map[string]*request.Principal{
+		"admin": {ID: "admin", Roles: []string{"admin"}},
+		"joe":   {ID: "joe", Roles: []string{"baker"}, Scopes: []string{"pies:write"}},
+		"sue":   {ID: "sue"},
+	}
+
+	// Authenticates as the user named by the 'X-User' header
+	authenticate := func(next canis.ContextHandler) canis.ContextHandler {
+		return canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			if principal, ok := users[r.Header.Get("X-User")]; ok {
+				ctx = request.WithPrincipal(ctx, principal)
+			}
+			next.ServeHTTP(ctx, w, r)
+		})
+	}
+
+	serve := func(method, path, user string) int {
+		req, _ := http.NewRequest(method, path, nil)
+		req.Header.Set("X-User", user)
+		resp := httptest.NewRecorder()
+		canis.Chain(authenticate).Then(router).ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	BeforeEach(func() {
+		decisions = nil
+		authz := request.NewAuthorizer(func(ctx context.Context, decision request.AuthDecision) {
+			decisions = append(decisions, decision)
+		})
+		noop := func(ctx canis.ParamContext, w http.ResponseWriter, r *http.Request) {}
+
+		router = canis.NewRouter()
+		router.GET("/v1/pies/:id", noop)
+		router.DELETE("/v1/pies/:id", noop, canis.Use(authz.Require(request.HasRole("admin"))))
+		router.PUT("/v1/users/:user/pies/:id", noop,
+			canis.Use(authz.Require(request.AnyOf(request.Owner("user"), request.HasRole("admin")))))
+		router.POST("/v1/pies", noop, canis.Use(authz.Require(request.HasRole("baker", "admin"), request.HasScopes("pies:write"))))
+	})
+
+	It("should require a role", func() {
+		Expect(serve("DELETE", "/v1/pies/1", "admin")).To(Equal(http.StatusOK))
+		Expect(serve("DELETE", "/v1/pies/1", "joe")).To(Equal(http.StatusForbidden))
+		Expect(serve("DELETE", "/v1/pies/1", "")).To(Equal(http.StatusUnauthorized))
+		Expect(serve("GET", "/v1/pies/1", "")).To(Equal(http.StatusOK))
+	})
+
+	It("should require all the rules", func() {
+		Expect(serve("POST", "/v1/pies", "joe")).To(Equal(http.StatusOK))
+		Expect(serve("POST", "/v1/pies", "admin")).To(Equal(http.StatusForbidden))
+		Expect(decisions[len(decisions)-1].Reason).To(Equal("requires scope 'pies:write'"))
+	})
+
+	It("should allow the owner named by a route param", func() {
+		Expect(serve("PUT", "/v1/users/joe/pies/1", "joe")).To(Equal(http.StatusOK))
+		Expect(serve("PUT", "/v1/users/joe/pies/1", "admin")).To(Equal(http.StatusOK))
+		Expect(serve("PUT", "/v1/users/joe/pies/1", "sue")).To(Equal(http.StatusForbidden))
+		Expect(decisions[len(decisions)-1].Reason).To(Equal("not the owner of 'user' or requires role 'admin'"))
+	})
+
+	It("should audit every decision", func() {
+		serve("DELETE", "/v1/pies/1", "admin")
+		serve("DELETE", "/v1/pies/2", "joe")
+		serve("DELETE", "/v1/pies/3", "")
+		Expect(decisions).To(Equal([]request.AuthDecision{
+			{Principal: users["admin"], Method: "DELETE", Path: "/v1/pies/1", Allowed: true},
+			{Principal: users["joe"], Method: "DELETE", Path: "/v1/pies/2", Reason: "requires role 'admin'"},
+			{Method: "DELETE", Path: "/v1/pies/3", Reason: "not authenticated"},
+		}))
+	})
+
+	It("should log decisions with AuditLogger()", func() {
+		var buf bytes.Buffer
+		log := logrus.New()
+		log.Out = &buf
+		log.Formatter = &logrus.TextFormatter{DisableColors: true, DisableTimestamp: true}
+		audit := request.AuditLogger(log)
+
+		audit(context.Background(), request.AuthDecision{Principal: users["joe"], Method: "DELETE",
+			Path: "/v1/pies/2", Reason: "requires role 'admin'"})
+		Expect(buf.String()).To(ContainSubstring("level=warning"))
+		Expect(buf.String()).To(ContainSubstring("allowed=false"))
+		Expect(buf.String()).To(ContainSubstring("user=joe"))
+		Expect(buf.String()).To(ContainSubstring(`reason="requires role 'admin'"`))
+	})
+
+	It("should work in the chain without a router", func() {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("X-User", "joe")
+		resp := httptest.NewRecorder()
+		canis.Chain(authenticate, request.Authorize(request.HasRole("baker"))).
+			ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {}).ServeHTTP(resp, req)
+		Expect(resp.Code).To(Equal(http.StatusOK))
+	})
+})
@@ -0,0 +1,133 @@
+package request
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/thrawn01/canis"
+	"golang.org/x/net/context"
+)
+
+// Decompress request bodies sent with 'Content-Encoding: gzip' or 'deflate'
+// and limit the size of the body to maxSize bytes as sent and maxDecodedSize
+// bytes once decompressed, which protects against decompression bombs. A limit
+// of zero disables it.
+//
+// Bodies with a 'Content-Length' over the limit are rejected with 413 (Request
+// Entity Too Large) before the handler runs, otherwise reads past a limit fail
+// with canis.ErrBodyTooLarge, which canis.Bind() returns as is. If the handler
+// has not responded once it returns, the 413 is sent for it. Bodies with any
+// other encoding are rejected with 415 (Unsupported Media Type). Errors are
+// sent with canis.Error().
+func DecodeBody(maxSize, maxDecodedSize int64) canis.Middleware {
+	return func(next canis.ContextHandler) canis.ContextHandler {
+		return canis.ContextHandlerFunc(func(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+			if maxSize > 0 && req.ContentLength > maxSize {
+				bodyTooLarge(resp)
+				return
+			}
+			if req.Body == nil || req.Body == http.NoBody {
+				next.ServeHTTP(ctx, resp, req)
+				return
+			}
+
+			var exceeded bool
+			original := req.Body
+			reader := limitBody(original, maxSize, &exceeded)
+
+			switch encoding := strings.ToLower(strings.TrimSpace(req.Header.Get("Content-Encoding"))); encoding {
+			case "", "identity":
+			case "gzip", "x-gzip", "deflate":
+				decoded, err := decompress(encoding, reader)
+				if err != nil {
+					if exceeded {
+						bodyTooLarge(resp)
+						return
+					}
+					canis.Error(resp, "invalid "+encoding+" request body; "+err.Error(), http.StatusBadRequest)
+					return
+				}
+				reader = limitBody(decoded, maxDecodedSize, &exceeded)
+				// The handler sees the body as if it was sent uncompressed
+				req.Header.Del("Content-Encoding")
+				req.Header.Del("Content-Length")
+				req.ContentLength = -1
+			default:
+				canis.Error(resp, "unsupported request Content-Encoding '"+encoding+"'", http.StatusUnsupportedMediaType)
+				return
+			}
+			req.Body = &decodedBody{reader, original}
+
+			writer := canis.NewResponseWriter(resp)
+			next.ServeHTTP(ctx, writer, req)
+			if exceeded && !writer.Written() {
+				bodyTooLarge(writer)
+			}
+		})
+	}
+}
+
+func bodyTooLarge(resp http.ResponseWriter) {
+	canis.Error(resp, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+}
+
+type decodedBody struct {
+	io.Reader
+	original io.Closer
+}
+
+func (self *decodedBody) Close() error {
+	return self.original.Close()
+}
+
+// Returns a reader for the compressed body. 'deflate' should be zlib wrapped
+// but some clients send raw deflate data, so both are accepted
+func decompress(encoding string, reader io.Reader) (io.Reader, error) {
+	if encoding != "deflate" {
+		return gzip.NewReader(reader)
+	}
+	buffered := bufio.NewReader(reader)
+	header, err := buffered.Peek(2)
+	if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+	return flate.NewReader(buffered), nil
+}
+
+// Returns a reader which fails with canis.ErrBodyTooLarge once more than limit bytes are read
+func limitBody(reader io.Reader, limit int64, exceeded *bool) io.Reader {
+	if limit <= 0 {
+		return reader
+	}
+	return &limitedReader{reader: reader, remaining: limit, exceeded: exceeded}
+}
+
+type limitedReader struct {
+	reader    io.Reader
+	remaining int64
+	exceeded  *bool
+}
+
+func (self *limitedReader) Read(buf []byte) (int, error) {
+	if self.remaining < 0 {
+		return 0, canis.ErrBodyTooLarge
+	}
+	// Read one byte more than allowed to tell a body of exactly the limit from one over it
+	if int64(len(buf)) > self.remaining+1 {
+		buf = buf[:self.remaining+1]
+	}
+	n, err := self.reader.Read(buf)
+	if int64(n) > self.remaining {
+		n = int(self.remaining)
+		self.remaining = -1
+		*self.exceeded = true
+		return n, canis.ErrBodyTooLarge
+	}
+	self.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,132 @@
+package request_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/thrawn01/canis"
+	"github.com/thrawn01/canis/request"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("DecodeBody()", func() {
+	compress := func(encoding string, body string) []byte {
+		var buf bytes.Buffer
+		var writer io.WriteCloser
+		switch encoding {
+		case "gzip":
+			writer = gzip.NewWriter(&buf)
+		case "zlib":
+			writer = zlib.NewWriter(&buf)
+		case "flate":
+			writer, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+		}
+		writer.Write([]byte(body))
+		writer.Close()
+		return buf.Bytes()
+	}
+
+	var received string
+	var readErr error
+	echo := canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		var body []byte
+		body, readErr = ioutil.ReadAll(r.Body)
+		received = string(body)
+		if readErr == nil {
+			w.Write(body)
+		}
+	})
+
+	BeforeEach(func() {
+		received, readErr = "", nil
+	})
+
+	It("should decompress gzip and deflate bodies", func() {
+		resp := serve(newRequest("POST", "/", bytes.NewReader(compress("gzip", "apple pie")), map[string]string{"Content-Encoding": "gzip"}), echo, request.DecodeBody(1000, 1000))
+		Expect(resp.Code).To(Equal(http.StatusOK))
+		Expect(resp.Body.String()).To(Equal("apple pie"))
+
+		resp = serve(newRequest("POST", "/", bytes.NewReader(compress("zlib", "cherry pie")), map[string]string{"Content-Encoding": "deflate"}), echo, request.DecodeBody(1000, 1000))
+		Expect(resp.Body.String()).To(Equal("cherry pie"))
+
+		resp = serve(newRequest("POST", "/", bytes.NewReader(compress("flate", "pecan pie")), map[string]string{"Content-Encoding": "deflate"}), echo, request.DecodeBody(1000, 1000))
+		Expect(resp.Body.String()).To(Equal("pecan pie"))
+
+		resp = serve(newRequest("POST", "/", strings.NewReader("plain pie"), nil), echo, request.DecodeBody(1000, 1000))
+		Expect(resp.Body.String()).To(Equal("plain pie"))
+	})
+
+	It("should hide the encoding from the handler", func() {
+		var header http.Header
+		var length int64
+		app := canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			header, length = r.Header, r.ContentLength
+		})
+		serve(newRequest("POST", "/", bytes.NewReader(compress("gzip", "apple pie")), map[string]string{"Content-Encoding": "gzip"}), app, request.DecodeBody(0, 0))
+		Expect(header.Get("Content-Encoding")).To(BeEmpty())
+		Expect(length).To(Equal(int64(-1)))
+	})
+
+	It("should reject bodies with a Content-Length over the limit", func() {
+		called := false
+		app := canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			called = true
+		})
+		resp := serve(newRequest("POST", "/", strings.NewReader(strings.Repeat("a", 101)), nil), app, request.DecodeBody(100, 0))
+		Expect(resp.Code).To(Equal(http.StatusRequestEntityTooLarge))
+		Expect(called).To(BeFalse())
+	})
+
+	It("should fail reads past the limit and respond with 413", func() {
+		req, _ := http.NewRequest("POST", "/", strings.NewReader(strings.Repeat("a", 101)))
+		// Unknown length, such as a chunked body
+		req.ContentLength = -1
+		resp := serve(req, echo, request.DecodeBody(100, 0))
+		Expect(readErr).To(Equal(canis.ErrBodyTooLarge))
+		Expect(resp.Code).To(Equal(http.StatusRequestEntityTooLarge))
+
+		resp = serve(newRequest("POST", "/", strings.NewReader(strings.Repeat("a", 100)), nil), echo, request.DecodeBody(100, 0))
+		Expect(resp.Code).To(Equal(http.StatusOK))
+		Expect(received).To(HaveLen(100))
+	})
+
+	It("should limit the size once decompressed", func() {
+		bomb := compress("gzip", strings.Repeat("a", 1<<20))
+		Expect(len(bomb)).To(BeNumerically("<", 4000))
+
+		resp := serve(newRequest("POST", "/", bytes.NewReader(bomb), map[string]string{"Content-Encoding": "gzip"}), echo, request.DecodeBody(4000, 1000))
+		Expect(readErr).To(Equal(canis.ErrBodyTooLarge))
+		Expect(resp.Code).To(Equal(http.StatusRequestEntityTooLarge))
+	})
+
+	It("should return ErrBodyTooLarge from canis.Bind()", func() {
+		var bindErr error
+		app := canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			var pie struct {
+				Flavor string `json:"flavor"`
+			}
+			r.Header.Set("Content-Type", "application/json")
+			bindErr = canis.Bind(nil, r, &pie)
+		})
+		body := `{"flavor": "` + strings.Repeat("a", 5000) + `"}`
+		resp := serve(newRequest("POST", "/", bytes.NewReader(compress("gzip", body)), map[string]string{"Content-Encoding": "gzip"}), app, request.DecodeBody(1000, 1000))
+		Expect(bindErr).To(Equal(canis.ErrBodyTooLarge))
+		Expect(resp.Code).To(Equal(http.StatusRequestEntityTooLarge))
+	})
+
+	It("should reject invalid and unsupported encodings", func() {
+		resp := serve(newRequest("POST", "/", strings.NewReader("not gzip"), map[string]string{"Content-Encoding": "gzip"}), echo, request.DecodeBody(1000, 1000))
+		Expect(resp.Code).To(Equal(http.StatusBadRequest))
+
+		resp = serve(newRequest("POST", "/", strings.NewReader("pie"), map[string]string{"Content-Encoding": "br"}), echo, request.DecodeBody(1000, 1000))
+		Expect(resp.Code).To(Equal(http.StatusUnsupportedMediaType))
+	})
+})
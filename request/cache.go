@@ -0,0 +1,523 @@
+package request
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thrawn01/canis"
+	"golang.org/x/net/context"
+)
+
+var (
+	// The largest response body NewCache() stores unless CacheMaxBodySize() is used
+	DefaultCacheMaxBodySize = 1 << 20
+	// Request headers that carry credentials, CacheCredentialHeaders() adds others
+	DefaultCacheCredentialHeaders = []string{"Authorization", "Cookie", APIKeyHeader}
+)
+
+// Status codes that may be cached
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+type CacheOption func(*ResponseCache)
+
+// Cache responses that do not have a 'Cache-Control' max-age for the ttl. By
+// default only responses with a max-age (or s-maxage) are cached.
+func CacheTTL(ttl time.Duration) CacheOption {
+	return func(self *ResponseCache) {
+		self.ttl = ttl
+	}
+}
+
+// Serve stale responses for up to window while they are revalidated in the
+// background, for responses that do not have a 'Cache-Control' stale-while-revalidate
+func CacheStaleWhileRevalidate(window time.Duration) CacheOption {
+	return func(self *ResponseCache) {
+		self.stale = window
+	}
+}
+
+// Do not store responses with a body larger than size bytes
+func CacheMaxBodySize(size int) CacheOption {
+	return func(self *ResponseCache) {
+		self.maxBodySize = size
+	}
+}
+
+// Treat the request headers as credentials in addition to DefaultCacheCredentialHeaders,
+// such as the header given to APIKey() or the session header of the application
+func CacheCredentialHeaders(names ...string) CacheOption {
+	return func(self *ResponseCache) {
+		self.credentialHeaders = append(self.credentialHeaders, names...)
+	}
+}
+
+// Returns a cache of complete responses to GET and HEAD requests, use Handler()
+// as the middleware. Responses are stored in the store, or a MemoryStore of
+// DefaultCacheSize if nil, keyed by the method, host, path, query and the
+// request headers named by the 'Vary' header of the response.
+//
+// 'Cache-Control' is honored; responses with 'no-store', 'no-cache' or
+// 'private', a 'Set-Cookie' header or 'Vary: *' are not stored. Responses are
+// fresh for the 's-maxage' or 'max-age' of the response, or CacheTTL() if
+// neither is set, and then served stale while revalidated in the background for
+// the 'stale-while-revalidate' of the response or CacheStaleWhileRevalidate().
+// Requests with 'no-store' skip the cache, 'no-cache' skips stored responses
+// and 'max-age' limits the age of a stored response. Responses to requests with
+// credentials; a header in DefaultCacheCredentialHeaders or CacheCredentialHeaders(),
+// or a principal set by BasicAuth(), APIKey(), JWT() or WithPrincipal(), are
+// only stored if marked 'public' or 's-maxage'.
+//
+// Successful POST, PUT, PATCH and DELETE requests invalidate the responses for
+// the path and the paths below it. Use InvalidateRoute() or InvalidatePrefix() to invalidate others.
+// The 'X-Cache' response header is set to HIT, STALE or MISS.
+func NewCache(store CacheStore, options ...CacheOption) *ResponseCache {
+	if store == nil {
+		store = NewMemoryStore(DefaultCacheSize)
+	}
+	cache := &ResponseCache{
+		store:             store,
+		maxBodySize:       DefaultCacheMaxBodySize,
+		credentialHeaders: append([]string(nil), DefaultCacheCredentialHeaders...),
+	}
+	for _, option := range options {
+		option(cache)
+	}
+	return cache
+}
+
+type ResponseCache struct {
+	store       CacheStore
+	ttl         time.Duration
+	stale       time.Duration
+	maxBodySize int
+	// Request headers that carry credentials
+	credentialHeaders []string
+	// Keys being revalidated in the background
+	revalidating sync.Map
+}
+
+// Remove every response produced by the route, by the name given with canis.Name() or the route pattern
+func (self *ResponseCache) InvalidateRoute(route string) {
+	self.store.InvalidateRoute(route)
+}
+
+// Remove every response for the path and the paths below it, '/v1/pies' removes '/v1/pies/1' but not '/v1/pies-old'
+func (self *ResponseCache) InvalidatePrefix(prefix string) {
+	self.store.InvalidatePrefix(prefix)
+}
+
+func (self *ResponseCache) Handler(next canis.ContextHandler) canis.ContextHandler {
+	return canis.ContextHandlerFunc(func(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" && req.Method != "HEAD" {
+			self.invalidate(ctx, next, resp, req)
+			return
+		}
+		control := parseCacheControl(req.Header.Get("Cache-Control"))
+		if _, ok := control["no-store"]; ok {
+			next.ServeHTTP(ctx, resp, req)
+			return
+		}
+
+		key := cacheKey(req)
+		_, noCache := control["no-cache"]
+		if !noCache && req.Header.Get("Pragma") != "no-cache" {
+			if cached, ok := self.lookup(key, req); ok && acceptableAge(control, cached) {
+				if time.Now().Before(cached.Expires) {
+					serveCached(resp, req, cached, "HIT")
+					return
+				}
+				serveCached(resp, req, cached, "STALE")
+				self.revalidate(ctx, next, key, req, cached.Route, cached.StaleUntil.Sub(cached.Expires))
+				return
+			}
+		}
+
+		resp.Header().Set("X-Cache", "MISS")
+		before := cloneHeader(resp.Header())
+		ctx, matched := canis.WithMatchedRoute(ctx)
+		ctx, record := withPrincipalRecord(ctx)
+		writer := &cacheWriter{resp: resp, status: http.StatusOK, maxBodySize: self.maxBodySize}
+		next.ServeHTTP(ctx, canis.NewFilterWriter(resp, writer), req)
+		self.save(key, req, writer, before, routeName(matched.Route), self.credentialed(ctx, req, record))
+	})
+}
+
+// Serve the unsafe request and invalidate the responses for the path if it succeeds
+func (self *ResponseCache) invalidate(ctx context.Context, next canis.ContextHandler, resp http.ResponseWriter, req *http.Request) {
+	writer := canis.NewResponseWriter(resp)
+	next.ServeHTTP(ctx, writer, req)
+	if req.Method != "OPTIONS" && writer.Status() < http.StatusBadRequest {
+		self.store.InvalidatePrefix(req.URL.Path)
+	}
+}
+
+// Returns the stored response for the request, following the 'Vary' index if there is one
+func (self *ResponseCache) lookup(key string, req *http.Request) (*CachedResponse, bool) {
+	cached, ok := self.store.Get(key)
+	if !ok {
+		return nil, false
+	}
+	if cached.Status == 0 {
+		return self.store.Get(variantKey(key, cached.Vary, req))
+	}
+	return cached, true
+}
+
+// Re-run the handler in the background to refresh the stored response, at most once per key at a
+// time. The handler gets the values of the request context, but not its deadline or cancellation
+// which end with the request, and a copy of the request. The context is cancelled after timeout,
+// so a handler that hangs does not stop the response from ever being refreshed again
+func (self *ResponseCache) revalidate(ctx context.Context, next canis.ContextHandler, key string, req *http.Request, route string, timeout time.Duration) {
+	if _, running := self.revalidating.LoadOrStore(key, true); running {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(detachedContext{ctx}, timeout)
+	ctx, _ = withTimings(ctx)
+	ctx, record := withPrincipalRecord(ctx)
+	background := req.WithContext(ctx)
+	copied := *req.URL
+	background.URL = &copied
+	background.Body = http.NoBody
+	background.Form, background.PostForm, background.MultipartForm = nil, nil, nil
+	background.Header = cloneHeader(req.Header)
+	for _, name := range conditionalHeaders {
+		background.Header.Del(name)
+	}
+
+	go func() {
+		defer self.revalidating.Delete(key)
+		defer cancel()
+		// Nothing recovers panics outside the request, the process would crash
+		defer func() {
+			if p := recover(); p != nil {
+				log.Printf("canis: panic revalidating '%s %s': %v\n%s", req.Method, req.URL, p, debug.Stack())
+			}
+		}()
+		discard := discardWriter{make(http.Header)}
+		writer := &cacheWriter{resp: discard, status: http.StatusOK, maxBodySize: self.maxBodySize}
+		next.ServeHTTP(ctx, canis.NewFilterWriter(discard, writer), background)
+		self.save(key, background, writer, nil, route, self.credentialed(ctx, background, record))
+	}()
+}
+
+// True if the request carries credentials, so the response may be meant for this client only
+func (self *ResponseCache) credentialed(ctx context.Context, req *http.Request, record *principalRecord) bool {
+	if GetPrincipal(ctx) != nil || record.principal != nil {
+		return true
+	}
+	for _, name := range self.credentialHeaders {
+		if req.Header.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Store the response if it may be cached. Only the headers that changed from before are stored,
+// as the others were set by middleware outside the cache for this request only
+func (self *ResponseCache) save(key string, req *http.Request, writer *cacheWriter, before http.Header, route string, credentialed bool) {
+	if writer.skip || !writer.wroteHeader || !cacheableStatus[writer.status] {
+		return
+	}
+	header := make(http.Header)
+	for name, values := range writer.header {
+		if name != "X-Cache" && !equalValues(before[name], values) {
+			header[name] = values
+		}
+	}
+
+	control := parseCacheControl(header.Get("Cache-Control"))
+	for _, directive := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := control[directive]; ok {
+			return
+		}
+	}
+	if header.Get("Set-Cookie") != "" {
+		return
+	}
+	if credentialed {
+		_, public := control["public"]
+		_, shared := control["s-maxage"]
+		if !public && !shared {
+			return
+		}
+	}
+
+	ttl := self.ttl
+	if maxAge, ok := controlSeconds(control, "s-maxage"); ok {
+		ttl = maxAge
+	} else if maxAge, ok := controlSeconds(control, "max-age"); ok {
+		ttl = maxAge
+	}
+	if ttl <= 0 {
+		return
+	}
+	stale := self.stale
+	if window, ok := controlSeconds(control, "stale-while-revalidate"); ok {
+		stale = window
+	}
+
+	vary := varyHeaders(header)
+	for _, name := range vary {
+		if name == "*" {
+			return
+		}
+	}
+
+	now := time.Now()
+	cached := &CachedResponse{
+		Status:     writer.status,
+		Header:     header,
+		Body:       writer.body.Bytes(),
+		Stored:     now,
+		Expires:    now.Add(ttl),
+		StaleUntil: now.Add(ttl + stale),
+		Route:      route,
+		Path:       req.URL.Path,
+	}
+
+	if len(vary) == 0 {
+		self.store.Set(key, cached)
+		return
+	}
+	// Store an index of the headers the response varies on under the key, then the response under its variant
+	index := *cached
+	index.Status, index.Header, index.Body, index.Vary = 0, nil, nil, vary
+	self.store.Set(key, &index)
+	self.store.Set(variantKey(key, vary, req), cached)
+}
+
+// Returns the name of the route, or the route pattern if unnamed
+func routeName(route *canis.Route) string {
+	if route == nil {
+		return ""
+	}
+	if route.Name != "" {
+		return route.Name
+	}
+	return route.Path
+}
+
+// True unless the request limits the age of the stored response with 'max-age'
+func acceptableAge(control map[string]string, cached *CachedResponse) bool {
+	maxAge, ok := controlSeconds(control, "max-age")
+	return !ok || time.Since(cached.Stored) <= maxAge
+}
+
+func serveCached(resp http.ResponseWriter, req *http.Request, cached *CachedResponse, state string) {
+	header := resp.Header()
+	for name, values := range cached.Header {
+		header[name] = append([]string(nil), values...)
+	}
+	header.Set("Age", strconv.Itoa(int(time.Since(cached.Stored)/time.Second)))
+	header.Set("X-Cache", state)
+
+	if cached.Status == http.StatusOK {
+		lastModified, _ := http.ParseTime(header.Get("Last-Modified"))
+		if evaluatePreconditions(req, header.Get("ETag"), lastModified) == http.StatusNotModified {
+			writeNotModified(resp)
+			return
+		}
+	}
+	resp.WriteHeader(cached.Status)
+	if req.Method != "HEAD" {
+		resp.Write(cached.Body)
+	}
+}
+
+// Returns 'METHOD host/path?query' with the query sorted, so the order of the parameters does not matter
+func cacheKey(req *http.Request) string {
+	query := req.URL.RawQuery
+	if query != "" {
+		if values, err := url.ParseQuery(query); err == nil {
+			query = values.Encode()
+		}
+	}
+	return req.Method + " " + strings.ToLower(req.Host) + req.URL.Path + "?" + query
+}
+
+// Returns the key with the values of the request headers the response varies on
+func variantKey(key string, vary []string, req *http.Request) string {
+	var buf bytes.Buffer
+	buf.WriteString(key)
+	for _, name := range vary {
+		buf.WriteByte('\n')
+		buf.WriteString(name)
+		buf.WriteByte(':')
+		buf.WriteString(strings.Join(req.Header[name], ","))
+	}
+	return buf.String()
+}
+
+// Returns the canonical, sorted names in the 'Vary' header
+func varyHeaders(header http.Header) []string {
+	var names []string
+	for _, line := range header["Vary"] {
+		for _, name := range strings.Split(line, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Parse a 'Cache-Control' header into its directives and their values
+func parseCacheControl(header string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value := part, ""
+		if pos := strings.IndexByte(part, '='); pos != -1 {
+			name, value = part[:pos], strings.Trim(part[pos+1:], `"`)
+		}
+		directives[strings.ToLower(strings.TrimSpace(name))] = value
+	}
+	return directives
+}
+
+func controlSeconds(control map[string]string, name string) (time.Duration, bool) {
+	value, ok := control[name]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+func cloneHeader(header http.Header) http.Header {
+	result := make(http.Header, len(header))
+	for name, values := range header {
+		result[name] = append([]string(nil), values...)
+	}
+	return result
+}
+
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Records the response as it is sent
+type cacheWriter struct {
+	resp        http.ResponseWriter
+	status      int
+	wroteHeader bool
+	// The headers when the status was sent
+	header      http.Header
+	body        bytes.Buffer
+	maxBodySize int
+	// Set if the response can not be stored
+	skip bool
+}
+
+func (self *cacheWriter) WriteHeader(status int) {
+	if !self.wroteHeader && (status < 100 || status >= 200 || status == http.StatusSwitchingProtocols) {
+		self.wroteHeader = true
+		self.status = status
+		self.header = cloneHeader(self.resp.Header())
+	}
+	self.resp.WriteHeader(status)
+}
+
+func (self *cacheWriter) Write(buf []byte) (int, error) {
+	if !self.wroteHeader {
+		self.WriteHeader(http.StatusOK)
+	}
+	if !self.skip {
+		if self.body.Len()+len(buf) > self.maxBodySize {
+			self.skip = true
+			self.body = bytes.Buffer{}
+		} else {
+			self.body.Write(buf)
+		}
+	}
+	return self.resp.Write(buf)
+}
+
+func (self *cacheWriter) Flush() {
+	if !self.wroteHeader {
+		self.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := self.resp.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (self *cacheWriter) Hijacked() {
+	self.skip = true
+}
+
+// Discards the response of a background revalidation
+type discardWriter struct {
+	header http.Header
+}
+
+func (self discardWriter) Header() http.Header {
+	return self.header
+}
+
+func (self discardWriter) WriteHeader(int) {}
+
+func (self discardWriter) Write(buf []byte) (int, error) {
+	return len(buf), nil
+}
+
+// Has the values of the context it wraps but is never done. The records that
+// middleware fill in for the request, such as the matched route, are hidden as
+// the request they belong to has completed.
+type detachedContext struct {
+	context.Context
+}
+
+func (self detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (self detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (self detachedContext) Err() error {
+	return nil
+}
+
+func (self detachedContext) Value(key interface{}) interface{} {
+	switch value := self.Context.Value(key).(type) {
+	case *canis.MatchedRoute, *principalRecord, *timings:
+		return nil
+	default:
+		return value
+	}
+}
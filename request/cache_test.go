@@ -0,0 +1,352 @@
+package request_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/thrawn01/canis"
+	"github.com/thrawn01/canis/request"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("NewCache()", func() {
+	var calls int32
+
+	// Responds with the number of calls, so cached responses can be told apart
+	counter := func(cacheControl string) canis.ContextHandlerFunc {
+		return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			count := atomic.AddInt32(&calls, 1)
+			if cacheControl != "" {
+				w.Header().Set("Cache-Control", cacheControl)
+			}
+			fmt.Fprintf(w, "call %d", count)
+		}
+	}
+
+	BeforeEach(func() {
+		atomic.StoreInt32(&calls, 0)
+	})
+
+	It("should serve cached responses by method, path and query", func() {
+		cache := request.NewCache(nil)
+		app := counter("max-age=60")
+
+		resp := serve(newRequest("GET", "/pies?a=1&b=2", nil, nil), app, cache.Handler)
+		Expect(resp.Header().Get("X-Cache")).To(Equal("MISS"))
+		Expect(resp.Body.String()).To(Equal("call 1"))
+
+		resp = serve(newRequest("GET", "/pies?b=2&a=1", nil, nil), app, cache.Handler)
+		Expect(resp.Header().Get("X-Cache")).To(Equal("HIT"))
+		Expect(resp.Header().Get("Age")).To(Equal("0"))
+		Expect(resp.Header().Get("Cache-Control")).To(Equal("max-age=60"))
+		Expect(resp.Body.String()).To(Equal("call 1"))
+
+		resp = serve(newRequest("HEAD", "/pies?a=1&b=2", nil, nil), app, cache.Handler)
+		Expect(resp.Header().Get("X-Cache")).To(Equal("MISS"))
+
+		Expect(serve(newRequest("GET", "/pies?a=2", nil, nil), app, cache.Handler).Body.String()).To(Equal("call 3"))
+		Expect(serve(newRequest("GET", "/cakes", nil, nil), app, cache.Handler).Body.String()).To(Equal("call 4"))
+	})
+
+	It("should store the responses of each host apart", func() {
+		cache := request.NewCache(nil)
+		app := counter("max-age=60")
+		Expect(serve(newRequest("GET", "http://pies.example.com/menu", nil, nil), app, cache.Handler).Body.String()).To(Equal("call 1"))
+		Expect(serve(newRequest("GET", "http://cakes.example.com/menu", nil, nil), app, cache.Handler).Body.String()).To(Equal("call 2"))
+		Expect(serve(newRequest("GET", "http://pies.example.com/menu", nil, nil), app, cache.Handler).Body.String()).To(Equal("call 1"))
+	})
+
+	It("should only cache responses with a lifetime", func() {
+		cache := request.NewCache(nil)
+		serve(newRequest("GET", "/pies", nil, nil), counter(""), cache.Handler)
+		Expect(serve(newRequest("GET", "/pies", nil, nil), counter(""), cache.Handler).Body.String()).To(Equal("call 2"))
+
+		cache = request.NewCache(nil, request.CacheTTL(time.Minute))
+		serve(newRequest("GET", "/pies", nil, nil), counter(""), cache.Handler)
+		Expect(serve(newRequest("GET", "/pies", nil, nil), counter(""), cache.Handler).Body.String()).To(Equal("call 3"))
+	})
+
+	It("should honor Cache-Control of the response", func() {
+		for _, control := range []string{"no-store", "no-cache", "private, max-age=60", "max-age=0"} {
+			cache := request.NewCache(nil, request.CacheTTL(time.Minute))
+			serve(newRequest("GET", "/pies", nil, nil), counter(control), cache.Handler)
+			resp := serve(newRequest("GET", "/pies", nil, nil), counter(control), cache.Handler)
+			Expect(resp.Header().Get("X-Cache")).To(Equal("MISS"), control)
+		}
+
+		cache := request.NewCache(nil)
+		cookie := canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+			counter("max-age=60")(ctx, w, r)
+		})
+		serve(newRequest("GET", "/pies", nil, nil), cookie, cache.Handler)
+		Expect(serve(newRequest("GET", "/pies", nil, nil), cookie, cache.Handler).Header().Get("X-Cache")).To(Equal("MISS"))
+	})
+
+	It("should honor Cache-Control of the request", func() {
+		cache := request.NewCache(nil)
+		app := counter("max-age=60")
+
+		serve(newRequest("GET", "/pies", nil, map[string]string{"Cache-Control": "no-store"}), app, cache.Handler)
+		Expect(serve(newRequest("GET", "/pies", nil, nil), app, cache.Handler).Body.String()).To(Equal("call 2"))
+
+		resp := serve(newRequest("GET", "/pies", nil, map[string]string{"Cache-Control": "no-cache"}), app, cache.Handler)
+		Expect(resp.Body.String()).To(Equal("call 3"))
+		// The fresh response replaced the stored one
+		Expect(serve(newRequest("GET", "/pies", nil, nil), app, cache.Handler).Body.String()).To(Equal("call 3"))
+
+		resp = serve(newRequest("GET", "/pies", nil, map[string]string{"Cache-Control": "max-age=0"}), app, cache.Handler)
+		Expect(resp.Body.String()).To(Equal("call 4"))
+	})
+
+	It("should only store responses to authorized requests if public", func() {
+		cache := request.NewCache(nil)
+		auth := map[string]string{"Authorization": "Bearer token"}
+		serve(newRequest("GET", "/pies", nil, auth), counter("max-age=60"), cache.Handler)
+		Expect(serve(newRequest("GET", "/pies", nil, auth), counter("max-age=60"), cache.Handler).Body.String()).To(Equal("call 2"))
+
+		serve(newRequest("GET", "/cakes", nil, auth), counter("public, max-age=60"), cache.Handler)
+		Expect(serve(newRequest("GET", "/cakes", nil, auth), counter("public, max-age=60"), cache.Handler).Body.String()).To(Equal("call 3"))
+	})
+
+	It("should not serve responses to requests with credentials to other clients", func() {
+		cache := request.NewCache(nil, request.CacheCredentialHeaders("X-Session"))
+		keys := request.APIKey(request.APIKeyHeader, request.StaticKeys(map[string]string{"k-123": "billing"}))
+		app := counter("max-age=60")
+
+		resp := serve(newRequest("GET", "/pies", nil, map[string]string{"X-Api-Key": "k-123"}), app, cache.Handler, keys)
+		Expect(resp.Code).To(Equal(http.StatusOK))
+		resp = serve(newRequest("GET", "/pies", nil, nil), app, cache.Handler, keys)
+		Expect(resp.Code).To(Equal(http.StatusUnauthorized))
+		Expect(resp.Header().Get("X-Cache")).To(Equal("MISS"))
+
+		for path, headers := range map[string]map[string]string{"/cakes": {"Cookie": "session=abc"}, "/buns": {"X-Session": "abc"}} {
+			serve(newRequest("GET", path, nil, headers), app, cache.Handler)
+			Expect(serve(newRequest("GET", path, nil, nil), app, cache.Handler).Header().Get("X-Cache")).To(Equal("MISS"))
+		}
+
+		// A principal set by middleware after the cache, whatever the credential
+		session := func(next canis.ContextHandler) canis.ContextHandler {
+			return canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+				next.ServeHTTP(request.WithPrincipal(ctx, &request.Principal{ID: "joe"}), w, r)
+			})
+		}
+		serve(newRequest("GET", "/tarts", nil, nil), app, cache.Handler, session)
+		Expect(serve(newRequest("GET", "/tarts", nil, nil), app, cache.Handler).Header().Get("X-Cache")).To(Equal("MISS"))
+	})
+
+	It("should store a response per value of the Vary headers", func() {
+		cache := request.NewCache(nil)
+		app := canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Vary", "Accept-Language")
+			counter("max-age=60")(ctx, w, r)
+		})
+		english := map[string]string{"Accept-Language": "en"}
+		french := map[string]string{"Accept-Language": "fr"}
+
+		Expect(serve(newRequest("GET", "/pies", nil, english), app, cache.Handler).Body.String()).To(Equal("call 1"))
+		Expect(serve(newRequest("GET", "/pies", nil, french), app, cache.Handler).Body.String()).To(Equal("call 2"))
+		Expect(serve(newRequest("GET", "/pies", nil, english), app, cache.Handler).Body.String()).To(Equal("call 1"))
+		resp := serve(newRequest("GET", "/pies", nil, french), app, cache.Handler)
+		Expect(resp.Body.String()).To(Equal("call 2"))
+		Expect(resp.Header().Get("X-Cache")).To(Equal("HIT"))
+
+		star := canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Vary", "*")
+			counter("max-age=60")(ctx, w, r)
+		})
+		serve(newRequest("GET", "/cakes", nil, nil), star, cache.Handler)
+		Expect(serve(newRequest("GET", "/cakes", nil, nil), star, cache.Handler).Header().Get("X-Cache")).To(Equal("MISS"))
+	})
+
+	It("should not store the headers set by middleware outside the cache", func() {
+		cache := request.NewCache(nil)
+		var id int32
+		outer := func(next canis.ContextHandler) canis.ContextHandler {
+			return canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Request-Id", fmt.Sprint(atomic.AddInt32(&id, 1)))
+				next.ServeHTTP(ctx, w, r)
+			})
+		}
+		handler := canis.Chain(outer, cache.Handler).Then(counter("max-age=60"))
+
+		for _, expected := range []string{"1", "2"} {
+			resp := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/pies", nil)
+			handler.ServeHTTP(resp, req)
+			Expect(resp.Header().Get("X-Request-Id")).To(Equal(expected))
+			Expect(resp.Body.String()).To(Equal("call 1"))
+		}
+	})
+
+	It("should answer conditional requests from the cache", func() {
+		cache := request.NewCache(nil)
+		app := canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"apple"`)
+			counter("max-age=60")(ctx, w, r)
+		})
+		serve(newRequest("GET", "/pies", nil, nil), app, cache.Handler)
+		resp := serve(newRequest("GET", "/pies", nil, map[string]string{"If-None-Match": `"apple"`}), app, cache.Handler)
+		Expect(resp.Code).To(Equal(http.StatusNotModified))
+		Expect(resp.Body.Len()).To(Equal(0))
+	})
+
+	It("should expire responses once their TTL passes", func() {
+		cache := request.NewCache(nil, request.CacheTTL(20*time.Millisecond))
+		app := counter("")
+		serve(newRequest("GET", "/pies", nil, nil), app, cache.Handler)
+		Expect(serve(newRequest("GET", "/pies", nil, nil), app, cache.Handler).Body.String()).To(Equal("call 1"))
+		time.Sleep(30 * time.Millisecond)
+		Expect(serve(newRequest("GET", "/pies", nil, nil), app, cache.Handler).Body.String()).To(Equal("call 2"))
+	})
+
+	It("should serve stale responses while revalidating", func() {
+		cache := request.NewCache(nil, request.CacheTTL(20*time.Millisecond),
+			request.CacheStaleWhileRevalidate(time.Minute))
+		app := counter("")
+		serve(newRequest("GET", "/pies", nil, nil), app, cache.Handler)
+		time.Sleep(30 * time.Millisecond)
+
+		resp := serve(newRequest("GET", "/pies", nil, nil), app, cache.Handler)
+		Expect(resp.Header().Get("X-Cache")).To(Equal("STALE"))
+		Expect(resp.Body.String()).To(Equal("call 1"))
+
+		Eventually(func() string {
+			return serve(newRequest("GET", "/pies", nil, nil), app, cache.Handler).Body.String()
+		}).Should(Equal("call 2"))
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(2)))
+	})
+
+	It("should revalidate with the values of the request context and survive a panic", func() {
+		cache := request.NewCache(nil, request.CacheTTL(20*time.Millisecond),
+			request.CacheStaleWhileRevalidate(time.Minute))
+		principals := make(chan *request.Principal, 1)
+		app := canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) > 1 {
+				principals <- request.GetPrincipal(ctx)
+				panic("no more pies")
+			}
+			// Responses to authenticated requests are only stored if public
+			w.Header().Set("Cache-Control", "public")
+			w.Write([]byte("pie"))
+		})
+		authenticate := func(next canis.ContextHandler) canis.ContextHandler {
+			return canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+				next.ServeHTTP(request.WithPrincipal(ctx, &request.Principal{ID: "joe"}), w, r)
+			})
+		}
+		handler := canis.Chain(authenticate, cache.Handler).Then(app)
+
+		for i := 0; i < 2; i++ {
+			req, _ := http.NewRequest("GET", "/pies", nil)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			time.Sleep(30 * time.Millisecond)
+		}
+		var principal *request.Principal
+		Eventually(principals).Should(Receive(&principal))
+		Expect(principal.ID).To(Equal("joe"))
+	})
+
+	It("should cancel a revalidation that outlives the stale window", func() {
+		cache := request.NewCache(nil, request.CacheTTL(20*time.Millisecond),
+			request.CacheStaleWhileRevalidate(50*time.Millisecond))
+		cancelled := make(chan error, 1)
+		app := canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) > 1 {
+				<-ctx.Done()
+				cancelled <- ctx.Err()
+				return
+			}
+			w.Write([]byte("pie"))
+		})
+		serve(newRequest("GET", "/pies", nil, nil), app, cache.Handler)
+		time.Sleep(30 * time.Millisecond)
+
+		Expect(serve(newRequest("GET", "/pies", nil, nil), app, cache.Handler).Header().Get("X-Cache")).To(Equal("STALE"))
+		Eventually(cancelled).Should(Receive(Equal(context.DeadlineExceeded)))
+	})
+
+	It("should evict the least recently used responses once the store is full", func() {
+		store := request.NewMemoryStore(1000)
+		cache := request.NewCache(store)
+		app := canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=60")
+			atomic.AddInt32(&calls, 1)
+			w.Write([]byte(strings.Repeat("x", 300)))
+		})
+		serve(newRequest("GET", "/1", nil, nil), app, cache.Handler)
+		serve(newRequest("GET", "/2", nil, nil), app, cache.Handler)
+		serve(newRequest("GET", "/3", nil, nil), app, cache.Handler)
+		// Use /1 so /2 is the least recently used
+		Expect(serve(newRequest("GET", "/1", nil, nil), app, cache.Handler).Header().Get("X-Cache")).To(Equal("HIT"))
+		serve(newRequest("GET", "/4", nil, nil), app, cache.Handler)
+
+		Expect(store.Size()).To(BeNumerically("<=", 1000))
+		Expect(serve(newRequest("GET", "/2", nil, nil), app, cache.Handler).Header().Get("X-Cache")).To(Equal("MISS"))
+		Expect(serve(newRequest("GET", "/4", nil, nil), app, cache.Handler).Header().Get("X-Cache")).To(Equal("HIT"))
+	})
+
+	It("should not store responses larger than the max body size", func() {
+		cache := request.NewCache(nil, request.CacheMaxBodySize(4))
+		serve(newRequest("GET", "/pies", nil, nil), counter("max-age=60"), cache.Handler)
+		Expect(serve(newRequest("GET", "/pies", nil, nil), counter("max-age=60"), cache.Handler).Body.String()).To(Equal("call 2"))
+	})
+
+	Describe("invalidation", func() {
+		var cache *request.ResponseCache
+		var router *canis.Router
+
+		BeforeEach(func() {
+			cache = request.NewCache(nil)
+			router = canis.NewRouter()
+			pie := func(ctx canis.ParamContext, w http.ResponseWriter, r *http.Request) {
+				counter("max-age=60")(ctx, w, r)
+			}
+			router.GET("/pies/:id", pie, canis.Name("pie"))
+			router.GET("/cakes/:id", pie)
+			router.PUT("/pies/:id", func(ctx canis.ParamContext, w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})
+			serve(newRequest("GET", "/pies/1", nil, nil), router.ServeHTTP, cache.Handler)
+			serve(newRequest("GET", "/cakes/1", nil, nil), router.ServeHTTP, cache.Handler)
+		})
+
+		cached := func(path string) bool {
+			return serve(newRequest("GET", path, nil, nil), router.ServeHTTP, cache.Handler).Header().Get("X-Cache") == "HIT"
+		}
+
+		It("should remove the responses of a route by name or pattern", func() {
+			cache.InvalidateRoute("pie")
+			Expect(cached("/pies/1")).To(BeFalse())
+			Expect(cached("/cakes/1")).To(BeTrue())
+
+			cache.InvalidateRoute("/cakes/:id")
+			Expect(cached("/cakes/1")).To(BeFalse())
+		})
+
+		It("should remove the responses for a path prefix", func() {
+			cache.InvalidatePrefix("/cakes/")
+			Expect(cached("/cakes/1")).To(BeFalse())
+			Expect(cached("/pies/1")).To(BeTrue())
+		})
+
+		It("should only match a prefix at a path segment", func() {
+			serve(newRequest("GET", "/pies/10", nil, nil), router.ServeHTTP, cache.Handler)
+			cache.InvalidatePrefix("/pies/1")
+			Expect(cached("/pies/1")).To(BeFalse())
+			Expect(cached("/pies/10")).To(BeTrue())
+		})
+
+		It("should remove the responses for a path modified by a request", func() {
+			Expect(serve(newRequest("PUT", "/pies/1", nil, nil), router.ServeHTTP, cache.Handler).Code).To(Equal(http.StatusNoContent))
+			Expect(cached("/pies/1")).To(BeFalse())
+			Expect(cached("/cakes/1")).To(BeTrue())
+		})
+	})
+})
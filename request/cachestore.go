@@ -0,0 +1,169 @@
+package request
+
+import (
+	"container/list"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A response stored by NewCache()
+type CachedResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	// When the response was stored
+	Stored time.Time
+	// The response is fresh until Expires, then may be served while it is revalidated until StaleUntil
+	Expires    time.Time
+	StaleUntil time.Time
+	// The name of the route that produced the response or if unnamed the route pattern
+	Route string
+	// The URL path requested
+	Path string
+	// The request headers named by the 'Vary' header of the response. If set
+	// on the entry stored for the path, the entry only lists the headers and
+	// the response is stored under a key that includes their values
+	Vary []string
+}
+
+// Returns the number of bytes the response uses, roughly
+func (self *CachedResponse) size() int64 {
+	size := int64(len(self.Body) + len(self.Route) + len(self.Path))
+	for key, values := range self.Header {
+		size += int64(len(key))
+		for _, value := range values {
+			size += int64(len(value))
+		}
+	}
+	for _, name := range self.Vary {
+		size += int64(len(name))
+	}
+	return size
+}
+
+// Stores the responses cached by NewCache(), implementations must be safe for
+// concurrent use. Implement this to share the cache between processes.
+type CacheStore interface {
+	// Returns the response stored under the key
+	Get(key string) (*CachedResponse, bool)
+	// Store the response under the key, replacing any response already stored
+	Set(key string, resp *CachedResponse)
+	// Remove every response produced by the route, by name or pattern
+	InvalidateRoute(route string)
+	// Remove every response for the path and the paths below it, matching
+	// whole segments so '/v1/pies' removes '/v1/pies/1' but not '/v1/pies-old'
+	InvalidatePrefix(prefix string)
+}
+
+// The default size of the MemoryStore used by NewCache(), in bytes
+var DefaultCacheSize int64 = 64 << 20
+
+// Returns a CacheStore that keeps at most maxBytes of responses in memory,
+// evicting the least recently used responses once full
+func NewMemoryStore(maxBytes int64) *MemoryStore {
+	return &MemoryStore{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+type MemoryStore struct {
+	mutex    sync.Mutex
+	maxBytes int64
+	size     int64
+	entries  map[string]*list.Element
+	// Most recently used at the front
+	lru *list.List
+}
+
+type memoryEntry struct {
+	key  string
+	resp *CachedResponse
+	size int64
+}
+
+func (self *MemoryStore) Get(key string) (*CachedResponse, bool) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	element, ok := self.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*memoryEntry)
+	// Too old to be served, even while revalidating
+	if time.Now().After(entry.resp.StaleUntil) {
+		self.remove(element)
+		return nil, false
+	}
+	self.lru.MoveToFront(element)
+	return entry.resp, true
+}
+
+func (self *MemoryStore) Set(key string, resp *CachedResponse) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if element, ok := self.entries[key]; ok {
+		self.remove(element)
+	}
+	entry := &memoryEntry{key: key, resp: resp, size: resp.size() + int64(len(key))}
+	if entry.size > self.maxBytes {
+		return
+	}
+	self.entries[key] = self.lru.PushFront(entry)
+	self.size += entry.size
+
+	for self.size > self.maxBytes {
+		self.remove(self.lru.Back())
+	}
+}
+
+func (self *MemoryStore) InvalidateRoute(route string) {
+	self.removeFunc(func(resp *CachedResponse) bool {
+		return resp.Route == route
+	})
+}
+
+func (self *MemoryStore) InvalidatePrefix(prefix string) {
+	self.removeFunc(func(resp *CachedResponse) bool {
+		return pathHasPrefix(resp.Path, prefix)
+	})
+}
+
+// True if the path is the prefix or below it
+func pathHasPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// Returns the number of bytes stored
+func (self *MemoryStore) Size() int64 {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.size
+}
+
+func (self *MemoryStore) removeFunc(match func(*CachedResponse) bool) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	for element := self.lru.Front(); element != nil; {
+		next := element.Next()
+		if match(element.Value.(*memoryEntry).resp) {
+			self.remove(element)
+		}
+		element = next
+	}
+}
+
+func (self *MemoryStore) remove(element *list.Element) {
+	entry := self.lru.Remove(element).(*memoryEntry)
+	delete(self.entries, entry.key)
+	self.size -= entry.size
+}
@@ -0,0 +1,138 @@
+package request
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/thrawn01/canis"
+)
+
+// The maximum number of bytes of a non 2XX response body ErrorLogger() and
+// StructuredLogger() log unless ErrorBodyLimit() is used
+var DefaultErrorBodyLimit = 1024
+
+// Replaces the values removed by RedactFields() and RedactPatterns()
+const redacted = "[REDACTED]"
+
+// Capture at most limit bytes of the body of non 2XX responses, the rest of
+// the body is logged as '...'. A limit of zero disables the capture.
+func ErrorBodyLimit(limit int) LoggerOption {
+	return func(self *RequestLogger) {
+		self.errorBodyLimit = limit
+	}
+}
+
+// Redact the values of the named JSON fields in the captured error body, such
+// that '{"token": "abc"}' is logged as '{"token": "[REDACTED]"}'. Fields are
+// matched anywhere in the body, even if the capture was cut short by ErrorBodyLimit().
+func RedactFields(fields ...string) LoggerOption {
+	names := make([]string, len(fields))
+	for i, field := range fields {
+		names[i] = regexp.QuoteMeta(field)
+	}
+	pattern := regexp.MustCompile(`("(?:` + strings.Join(names, "|") + `)"\s*:\s*)` +
+		`(?:"(?:[^"\\]|\\.)*"?|[^,}\]\s]+)`)
+	return func(self *RequestLogger) {
+		if len(fields) != 0 {
+			self.redactFields = pattern
+		}
+	}
+}
+
+// Redact any text in the captured error body that matches one of the regular
+// expressions. Panics if a pattern is invalid.
+func RedactPatterns(patterns ...string) LoggerOption {
+	compiled := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			panic(fmt.Sprintf("invalid redact pattern '%s'; %s", pattern, err))
+		}
+		compiled[i] = regex
+	}
+	return func(self *RequestLogger) {
+		self.redactPatterns = append(self.redactPatterns, compiled...)
+	}
+}
+
+// Captures the start of a non 2XX response body across writes
+type errorCapture struct {
+	logger    *RequestLogger
+	buf       *bytes.Buffer
+	truncated bool
+	skip      bool
+}
+
+func (self *errorCapture) write(resp canis.ResponseWriter, buf []byte) {
+	status := resp.Status()
+	if self.skip || self.logger.errorBodyLimit <= 0 || (status >= 200 && status < 300) {
+		return
+	}
+	if self.buf == nil {
+		// Only decide once, handlers may not set the 'Content-Type' until the first write
+		if !isText(resp.Header().Get("Content-Type"), buf) {
+			self.skip = true
+			return
+		}
+		self.buf = self.logger.bufferPool.Get()
+	}
+
+	remaining := self.logger.errorBodyLimit - self.buf.Len()
+	if len(buf) > remaining {
+		buf = buf[:remaining]
+		self.truncated = true
+	}
+	// Copy as the caller is free to reuse buf once Write() returns
+	self.buf.Write(buf)
+}
+
+// Returns the captured body with the configured values redacted, or nil if nothing was captured
+func (self *errorCapture) body() []byte {
+	if self.buf == nil {
+		return nil
+	}
+	result := self.buf.Bytes()
+	if self.logger.redactFields != nil {
+		result = self.logger.redactFields.ReplaceAll(result, []byte(`${1}"`+redacted+`"`))
+	}
+	for _, pattern := range self.logger.redactPatterns {
+		result = pattern.ReplaceAllLiteral(result, []byte(redacted))
+	}
+	if self.truncated {
+		result = append(bytes.TrimSuffix(result, []byte("\n")), "..."...)
+	}
+	return result
+}
+
+// Return the buffer to the pool, the result of body() must no longer be used
+func (self *errorCapture) release() {
+	if self.buf != nil {
+		self.logger.bufferPool.Put(self.buf)
+		self.buf = nil
+	}
+}
+
+// True if the content type is human readable, if it was not set the body is sniffed
+func isText(contentType string, body []byte) bool {
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript",
+		"application/x-www-form-urlencoded":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,305 @@
+package request
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/thrawn01/canis"
+	"golang.org/x/net/context"
+)
+
+// Responses smaller than this are not compressed unless CompressMinSize() is used
+var DefaultCompressMinSize = 1024
+
+type CompressOption func(*compressor)
+
+// Only compress responses of at least this many bytes, as small responses can grow once compressed
+func CompressMinSize(size int) CompressOption {
+	return func(self *compressor) {
+		self.minSize = size
+	}
+}
+
+// Compress the response with brotli, gzip or deflate, whichever the client
+// prefers in 'Accept-Encoding' (brotli is used if the client has no preference).
+// Responses that are smaller than CompressMinSize(), already have a
+// 'Content-Encoding' or have a content type that is already compressed, such
+// as images, video, audio or archives, are sent as is.
+//
+// 'Vary: Accept-Encoding' is always set and 'Content-Length' is removed from
+// compressed responses. Flush() sends the data compressed so far, so streaming
+// handlers keep working. The response writer supports the same optional
+// interfaces as the writer it wraps. If the handler panics, other than with
+// canis.Abort(), the compressed stream is left unfinished.
+func Compress(options ...CompressOption) canis.Middleware {
+	compress := &compressor{minSize: DefaultCompressMinSize}
+	for _, option := range options {
+		option(compress)
+	}
+	return compress.Handler
+}
+
+type compressor struct {
+	minSize int
+}
+
+func (self *compressor) Handler(next canis.ContextHandler) canis.ContextHandler {
+	return canis.ContextHandlerFunc(func(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(req.Header.Get("Accept-Encoding"))
+		if encoding == nil {
+			next.ServeHTTP(ctx, resp, req)
+			return
+		}
+
+		writer := &compressWriter{resp: resp, encoding: encoding, minSize: self.minSize, status: http.StatusOK}
+		defer func() {
+			rcv := recover()
+			if rcv != nil && !canis.IsAbort(rcv) {
+				// Don't finish a response the handler never completed
+				writer.release()
+				panic(rcv)
+			}
+			writer.close()
+			if rcv != nil {
+				panic(rcv)
+			}
+		}()
+		next.ServeHTTP(ctx, canis.NewFilterWriter(resp, writer), req)
+	})
+}
+
+// A supported 'Content-Encoding' and a pool of its writers
+type contentEncoding struct {
+	name string
+	pool sync.Pool
+}
+
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// In order of preference when the client accepts several with the same quality
+var contentEncodings = []*contentEncoding{
+	{name: "br", pool: sync.Pool{New: func() interface{} {
+		return brotli.NewWriterLevel(nil, brotli.DefaultCompression)
+	}}},
+	{name: "gzip", pool: sync.Pool{New: func() interface{} {
+		return gzip.NewWriter(nil)
+	}}},
+	{name: "deflate", pool: sync.Pool{New: func() interface{} {
+		writer, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return writer
+	}}},
+}
+
+// Returns the encoding the client prefers, or nil if it accepts none of ours
+func negotiateEncoding(accept string) *contentEncoding {
+	if accept == "" {
+		return nil
+	}
+	qualities := make(map[string]float64)
+	for _, part := range strings.Split(accept, ",") {
+		name, quality := part, 1.0
+		if pos := strings.IndexByte(part, ';'); pos != -1 {
+			name = part[:pos]
+			param := strings.TrimSpace(part[pos+1:])
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		qualities[strings.ToLower(strings.TrimSpace(name))] = quality
+	}
+
+	var result *contentEncoding
+	best := 0.0
+	for _, encoding := range contentEncodings {
+		quality, ok := qualities[encoding.name]
+		if !ok {
+			quality, ok = qualities["*"]
+		}
+		if ok && quality > best {
+			result, best = encoding, quality
+		}
+	}
+	return result
+}
+
+// Content types that are already compressed
+var compressedTypes = map[string]bool{
+	"application/gzip":             true,
+	"application/x-gzip":           true,
+	"application/zip":              true,
+	"application/x-bzip2":          true,
+	"application/x-xz":             true,
+	"application/x-7z-compressed":  true,
+	"application/x-rar-compressed": true,
+	"application/zstd":             true,
+	"application/octet-stream":     true,
+	"font/woff":                    true,
+	"font/woff2":                   true,
+}
+
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if mediaType == "image/svg+xml" {
+		return true
+	}
+	if strings.HasPrefix(mediaType, "image/") || strings.HasPrefix(mediaType, "video/") ||
+		strings.HasPrefix(mediaType, "audio/") {
+		return false
+	}
+	return !compressedTypes[mediaType]
+}
+
+// Buffers the start of the response until it knows if it should be compressed
+type compressWriter struct {
+	resp     http.ResponseWriter
+	encoding *contentEncoding
+	minSize  int
+
+	status      int
+	wroteHeader bool
+	// The start of the body, until decided
+	buf     []byte
+	decided bool
+	encoder encoder
+}
+
+func (self *compressWriter) WriteHeader(status int) {
+	if self.wroteHeader {
+		return
+	}
+	// Informational responses are sent as is
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		self.resp.WriteHeader(status)
+		return
+	}
+	self.wroteHeader = true
+	self.status = status
+}
+
+func (self *compressWriter) Write(buf []byte) (int, error) {
+	if !self.wroteHeader {
+		self.WriteHeader(http.StatusOK)
+	}
+	if !self.decided {
+		self.buf = append(self.buf, buf...)
+		if len(self.buf) < self.minSize {
+			return len(buf), nil
+		}
+		if err := self.decide(false); err != nil {
+			return 0, err
+		}
+		return len(buf), nil
+	}
+	if self.encoder != nil {
+		return self.encoder.Write(buf)
+	}
+	return self.resp.Write(buf)
+}
+
+// Decide whether to compress, then send the status and everything buffered so far. The
+// body is compressed once it reaches the minimum size or if flushed, as a streamed body is likely to grow
+func (self *compressWriter) decide(flushing bool) error {
+	self.decided = true
+	header := self.resp.Header()
+	if header.Get("Content-Type") == "" && len(self.buf) != 0 {
+		// Sniff now, once compressed the server can no longer tell
+		header.Set("Content-Type", http.DetectContentType(self.buf))
+	}
+
+	if (flushing || (len(self.buf) != 0 && len(self.buf) >= self.minSize)) && self.shouldCompress(header) {
+		header.Set("Content-Encoding", self.encoding.name)
+		header.Del("Content-Length")
+		// The compressed bytes differ from those a strong ETag was computed from
+		if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			header.Set("ETag", "W/"+etag)
+		}
+		self.encoder = self.encoding.pool.Get().(encoder)
+		self.encoder.Reset(self.resp)
+	}
+
+	self.resp.WriteHeader(self.status)
+	buf := self.buf
+	self.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if self.encoder != nil {
+		_, err := self.encoder.Write(buf)
+		return err
+	}
+	_, err := self.resp.Write(buf)
+	return err
+}
+
+func (self *compressWriter) shouldCompress(header http.Header) bool {
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	if self.status == http.StatusNoContent || self.status == http.StatusNotModified {
+		return false
+	}
+	return compressible(header.Get("Content-Type"))
+}
+
+// Send everything written so far
+func (self *compressWriter) Flush() {
+	if !self.decided {
+		if !self.wroteHeader {
+			self.WriteHeader(http.StatusOK)
+		}
+		self.decide(true)
+	}
+	if self.encoder != nil {
+		self.encoder.Flush()
+	}
+	if flusher, ok := self.resp.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Called once the handler returns
+func (self *compressWriter) close() {
+	if !self.decided {
+		// Nothing was written, let the server send the default response
+		if !self.wroteHeader {
+			return
+		}
+		self.decide(false)
+	}
+	if self.encoder != nil {
+		self.encoder.Close()
+		self.release()
+	}
+}
+
+// Return the encoder to the pool without writing the end of the stream
+func (self *compressWriter) release() {
+	if self.encoder != nil {
+		self.encoder.Reset(nil)
+		self.encoding.pool.Put(self.encoder)
+		self.encoder = nil
+	}
+}
+
+// Nothing is compressed once the connection is taken over
+func (self *compressWriter) Hijacked() {
+	self.decided = true
+}
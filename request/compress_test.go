@@ -0,0 +1,204 @@
+package request_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/thrawn01/canis"
+	"github.com/thrawn01/canis/request"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("Compress()", func() {
+	payload := strings.Repeat("apple pie, cherry pie, pecan pie. ", 100)
+
+	text := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", "3400")
+		w.Write([]byte(payload[:1000]))
+		w.Write([]byte(payload[1000:]))
+	}
+
+	decode := func(resp *httptest.ResponseRecorder) string {
+		var body []byte
+		var err error
+		switch resp.Header().Get("Content-Encoding") {
+		case "gzip":
+			reader, gzErr := gzip.NewReader(resp.Body)
+			Expect(gzErr).NotTo(HaveOccurred())
+			body, err = ioutil.ReadAll(reader)
+		case "deflate":
+			body, err = ioutil.ReadAll(flate.NewReader(resp.Body))
+		case "br":
+			body, err = ioutil.ReadAll(brotli.NewReader(resp.Body))
+		default:
+			body = resp.Body.Bytes()
+		}
+		Expect(err).NotTo(HaveOccurred())
+		return string(body)
+	}
+
+	It("should compress with the encoding the client prefers", func() {
+		for accept, expected := range map[string]string{
+			"gzip":                     "gzip",
+			"deflate":                  "deflate",
+			"br":                       "br",
+			"gzip, deflate, br":        "br",
+			"gzip;q=1.0, br;q=0.5":     "gzip",
+			"*":                        "br",
+			"br;q=0, *;q=0.1":          "gzip",
+			"deflate, gzip;q=0.8, foo": "deflate",
+		} {
+			resp := serve(newRequest("GET", "/", nil, map[string]string{"Accept-Encoding": accept}), text, request.Compress())
+			Expect(resp.Header().Get("Content-Encoding")).To(Equal(expected), accept)
+			Expect(resp.Header().Get("Content-Length")).To(BeEmpty())
+			Expect(resp.Header().Get("Vary")).To(Equal("Accept-Encoding"))
+			Expect(resp.Body.Len()).To(BeNumerically("<", len(payload)))
+			Expect(decode(resp)).To(Equal(payload))
+		}
+	})
+
+	It("should not compress if the client accepts none of the encodings", func() {
+		for _, accept := range []string{"", "identity", "gzip;q=0"} {
+			resp := serve(newRequest("GET", "/", nil, map[string]string{"Accept-Encoding": accept}), text, request.Compress())
+			Expect(resp.Header().Get("Content-Encoding")).To(BeEmpty())
+			Expect(resp.Header().Get("Vary")).To(Equal("Accept-Encoding"))
+			Expect(resp.Body.String()).To(Equal(payload))
+		}
+	})
+
+	It("should not compress small responses", func() {
+		resp := serve(newRequest("GET", "/", nil, map[string]string{"Accept-Encoding": "gzip"}), func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("pie"))
+		}, request.Compress())
+		Expect(resp.Code).To(Equal(http.StatusCreated))
+		Expect(resp.Header().Get("Content-Encoding")).To(BeEmpty())
+		Expect(resp.Body.String()).To(Equal("pie"))
+
+		resp = serve(newRequest("GET", "/", nil, map[string]string{"Accept-Encoding": "gzip"}), text, request.Compress(request.CompressMinSize(10000)))
+		Expect(resp.Header().Get("Content-Encoding")).To(BeEmpty())
+		Expect(resp.Header().Get("Content-Length")).To(Equal("3400"))
+		Expect(resp.Body.String()).To(Equal(payload))
+	})
+
+	It("should not compress content types that are already compressed", func() {
+		for _, contentType := range []string{"image/png", "application/zip", "video/mp4", "font/woff2"} {
+			resp := serve(newRequest("GET", "/", nil, map[string]string{"Accept-Encoding": "gzip"}), func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", contentType)
+				w.Write([]byte(payload))
+			}, request.Compress())
+			Expect(resp.Header().Get("Content-Encoding")).To(BeEmpty(), contentType)
+			Expect(resp.Body.String()).To(Equal(payload))
+		}
+	})
+
+	It("should sniff the content type before compressing", func() {
+		resp := serve(newRequest("GET", "/", nil, map[string]string{"Accept-Encoding": "gzip"}), func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("<html>" + payload))
+		}, request.Compress())
+		Expect(resp.Header().Get("Content-Type")).To(Equal("text/html; charset=utf-8"))
+		Expect(resp.Header().Get("Content-Encoding")).To(Equal("gzip"))
+	})
+
+	It("should not compress responses already encoded by the handler", func() {
+		var encoded bytes.Buffer
+		writer := gzip.NewWriter(&encoded)
+		writer.Write([]byte(payload))
+		writer.Close()
+
+		resp := serve(newRequest("GET", "/", nil, map[string]string{"Accept-Encoding": "gzip"}), func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(encoded.Bytes())
+		}, request.Compress())
+		Expect(decode(resp)).To(Equal(payload))
+	})
+
+	It("should weaken a strong ETag when compressing", func() {
+		resp := serve(newRequest("GET", "/", nil, map[string]string{"Accept-Encoding": "gzip"}), func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"v1"`)
+			text(ctx, w, r)
+		}, request.Compress())
+		Expect(resp.Header().Get("ETag")).To(Equal(`W/"v1"`))
+	})
+
+	It("should send what was compressed so far on Flush()", func() {
+		resp := httptest.NewRecorder()
+		var flushed string
+		app := canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("data: pie\n\n"))
+			flusher, ok := w.(http.Flusher)
+			Expect(ok).To(BeTrue())
+			flusher.Flush()
+
+			reader, err := gzip.NewReader(bytes.NewReader(resp.Body.Bytes()))
+			Expect(err).NotTo(HaveOccurred())
+			buf := make([]byte, 11)
+			_, err = io.ReadFull(reader, buf)
+			Expect(err).NotTo(HaveOccurred())
+			flushed = string(buf)
+			w.Write([]byte("data: cake\n\n"))
+		})
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		canis.Chain(request.Compress()).Then(app).ServeHTTP(resp, req)
+
+		Expect(resp.Flushed).To(BeTrue())
+		Expect(flushed).To(Equal("data: pie\n\n"))
+		Expect(decode(resp)).To(Equal("data: pie\n\ndata: cake\n\n"))
+	})
+
+	It("should only finish the stream if the handler returned or aborted", func() {
+		serveDirect := func(app canis.ContextHandlerFunc) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			Expect(func() {
+				request.Compress()(app).ServeHTTP(context.Background(), resp, req)
+			}).To(Panic())
+			return resp
+		}
+
+		resp := serveDirect(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(payload))
+			panic("oven on fire")
+		})
+		reader, err := gzip.NewReader(resp.Body)
+		if err == nil {
+			_, err = ioutil.ReadAll(reader)
+		}
+		Expect(err).To(HaveOccurred())
+
+		resp = serveDirect(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			canis.Abort(w, payload, http.StatusForbidden)
+		})
+		Expect(resp.Code).To(Equal(http.StatusForbidden))
+		Expect(resp.Header().Get("Content-Encoding")).To(Equal("gzip"))
+		Expect(decode(resp)).To(Equal(payload + "\n"))
+	})
+
+	It("should only implement the optional interfaces of the wrapped writer", func() {
+		var flusher, hijacker bool
+		app := canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			_, flusher = w.(http.Flusher)
+			_, hijacker = w.(http.Hijacker)
+		})
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		canis.Chain(request.Compress()).Then(app).ServeHTTP(struct{ http.ResponseWriter }{httptest.NewRecorder()}, req)
+		Expect(flusher).To(BeFalse())
+		Expect(hijacker).To(BeFalse())
+	})
+})
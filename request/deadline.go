@@ -0,0 +1,111 @@
+package request
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/thrawn01/canis"
+	"golang.org/x/net/context"
+)
+
+// The header clients use to send how long they will wait for the response, such as '250ms'
+const RequestTimeoutHeader = "X-Request-Timeout"
+
+// The header gRPC clients use to send their timeout, such as '250m'
+const GRPCTimeoutHeader = "Grpc-Timeout"
+
+// Set a deadline on the context of the request to the lower of the budget the
+// client sent and the timeout. The client budget is read from 'X-Request-Timeout',
+// a duration such as '250ms' or '1.5s' (a bare number is in milliseconds), or
+// from 'Grpc-Timeout'. Invalid, zero or negative budgets are ignored and the
+// timeout applies, a timeout of zero means only the client budget applies.
+//
+// A deadline already on the context is never extended, so a Timeout() earlier
+// in the chain still applies. Handlers can use Remaining() to pass the budget
+// that is left on to downstream calls with InjectDeadline().
+func ClientDeadline(timeout time.Duration) canis.Middleware {
+	return func(next canis.ContextHandler) canis.ContextHandler {
+		return canis.ContextHandlerFunc(func(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+			budget, ok := clientBudget(req.Header)
+			if !ok || (timeout > 0 && timeout < budget) {
+				budget = timeout
+			}
+			if budget > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, budget)
+				defer cancel()
+			}
+			next.ServeHTTP(ctx, resp, req)
+		})
+	}
+}
+
+// Returns the time left until the deadline of the context, false if it has no deadline
+func Remaining(ctx context.Context) (time.Duration, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return deadline.Sub(time.Now()), true
+}
+
+// Set the 'X-Request-Timeout' header of an outgoing request to the budget
+// left on the context, so downstream services give up when we would
+func InjectDeadline(ctx context.Context, out *http.Request) {
+	remaining, ok := Remaining(ctx)
+	if !ok {
+		return
+	}
+	if remaining < time.Millisecond {
+		remaining = 0
+	}
+	out.Header.Set(RequestTimeoutHeader, strconv.FormatInt(int64(remaining/time.Millisecond), 10)+"ms")
+}
+
+// Returns the budget sent by the client, false if none or it is invalid. A
+// budget of zero would expire the request before it starts, so is also ignored
+func clientBudget(header http.Header) (time.Duration, bool) {
+	if value := header.Get(RequestTimeoutHeader); value != "" {
+		if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+			// Larger budgets do not fit in a time.Duration
+			if ms <= 0 || ms > math.MaxInt64/int64(time.Millisecond) {
+				return 0, false
+			}
+			return time.Duration(ms) * time.Millisecond, true
+		}
+		duration, err := time.ParseDuration(value)
+		return duration, err == nil && duration > 0
+	}
+	if value := header.Get(GRPCTimeoutHeader); value != "" {
+		duration, ok := parseGRPCTimeout(value)
+		return duration, ok && duration > 0
+	}
+	return 0, false
+}
+
+var grpcTimeoutUnits = map[byte]time.Duration{
+	'H': time.Hour,
+	'M': time.Minute,
+	'S': time.Second,
+	'm': time.Millisecond,
+	'u': time.Microsecond,
+	'n': time.Nanosecond,
+}
+
+// Parse a gRPC timeout; at most 8 digits followed by the unit, such as '250m'
+func parseGRPCTimeout(value string) (time.Duration, bool) {
+	if len(value) < 2 || len(value) > 9 {
+		return 0, false
+	}
+	unit, ok := grpcTimeoutUnits[value[len(value)-1]]
+	if !ok {
+		return 0, false
+	}
+	count, err := strconv.ParseUint(value[:len(value)-1], 10, 64)
+	if err != nil || count > uint64(math.MaxInt64/int64(unit)) {
+		return 0, false
+	}
+	return time.Duration(count) * unit, true
+}
@@ -0,0 +1,89 @@
+package request_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/thrawn01/canis"
+	"github.com/thrawn01/canis/request"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("ClientDeadline()", func() {
+	budget := func(headers map[string]string, middleware ...interface{}) (time.Duration, bool) {
+		var remaining time.Duration
+		var ok bool
+		app := canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			remaining, ok = request.Remaining(ctx)
+		})
+		req, _ := http.NewRequest("GET", "/", nil)
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		canis.Chain(middleware...).Then(app).ServeHTTP(httptest.NewRecorder(), req)
+		return remaining, ok
+	}
+
+	It("should use the client budget if it is lower than the timeout", func() {
+		remaining, ok := budget(map[string]string{"X-Request-Timeout": "250ms"}, request.ClientDeadline(time.Second))
+		Expect(ok).To(BeTrue())
+		Expect(remaining).To(BeNumerically("~", 250*time.Millisecond, 20*time.Millisecond))
+
+		remaining, _ = budget(map[string]string{"X-Request-Timeout": "300"}, request.ClientDeadline(time.Second))
+		Expect(remaining).To(BeNumerically("~", 300*time.Millisecond, 20*time.Millisecond))
+	})
+
+	It("should use the timeout if it is lower than the client budget", func() {
+		remaining, _ := budget(map[string]string{"X-Request-Timeout": "1m"}, request.ClientDeadline(time.Second))
+		Expect(remaining).To(BeNumerically("~", time.Second, 20*time.Millisecond))
+	})
+
+	It("should parse the gRPC timeout", func() {
+		remaining, _ := budget(map[string]string{"Grpc-Timeout": "2S"}, request.ClientDeadline(time.Minute))
+		Expect(remaining).To(BeNumerically("~", 2*time.Second, 20*time.Millisecond))
+
+		remaining, _ = budget(map[string]string{"Grpc-Timeout": "150m"}, request.ClientDeadline(0))
+		Expect(remaining).To(BeNumerically("~", 150*time.Millisecond, 20*time.Millisecond))
+	})
+
+	It("should ignore invalid budgets", func() {
+		for _, headers := range []map[string]string{
+			{"X-Request-Timeout": "soon"},
+			{"X-Request-Timeout": "-5s"},
+			{"X-Request-Timeout": "9223372036854775"},
+			{"Grpc-Timeout": "99999999H"},
+			{"Grpc-Timeout": "123456789S"},
+			{"Grpc-Timeout": "10x"},
+			{"X-Request-Timeout": "0"},
+			{"X-Request-Timeout": "0s"},
+			{"Grpc-Timeout": "0S"},
+		} {
+			remaining, _ := budget(headers, request.ClientDeadline(time.Second))
+			Expect(remaining).To(BeNumerically("~", time.Second, 20*time.Millisecond))
+		}
+		for _, value := range []string{"soon", "9223372036854775", "0"} {
+			_, ok := budget(map[string]string{"X-Request-Timeout": value}, request.ClientDeadline(0))
+			Expect(ok).To(BeFalse())
+		}
+	})
+
+	It("should never extend an earlier deadline", func() {
+		remaining, _ := budget(map[string]string{"X-Request-Timeout": "1m"},
+			request.Timeout(100*time.Millisecond), request.ClientDeadline(0))
+		Expect(remaining).To(BeNumerically("~", 100*time.Millisecond, 20*time.Millisecond))
+	})
+
+	It("should inject the remaining budget into outgoing requests", func() {
+		out, _ := http.NewRequest("GET", "http://inventory/pies", nil)
+		request.InjectDeadline(context.Background(), out)
+		Expect(out.Header.Get("X-Request-Timeout")).To(BeEmpty())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		request.InjectDeadline(ctx, out)
+		Expect(out.Header.Get("X-Request-Timeout")).To(MatchRegexp(`^1\d{3}ms$`))
+	})
+})
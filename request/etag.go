@@ -0,0 +1,290 @@
+package request
+
+import (
+	"bytes"
+	"encoding/hex"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thrawn01/canis"
+	"golang.org/x/net/context"
+)
+
+type ETagOption func(*etagConfig)
+
+type etagConfig struct {
+	validators func(context.Context, *http.Request) (string, time.Time)
+}
+
+// Evaluate the conditional headers of PUT, PATCH, DELETE and POST requests
+// against the ETag and modification time returned by the validators func before
+// the handler is called, either may be empty if unknown. The func should read
+// the stored validators of the resource without side effects.
+func ETagValidators(validators func(ctx context.Context, req *http.Request) (string, time.Time)) ETagOption {
+	return func(self *etagConfig) {
+		self.validators = validators
+	}
+}
+
+// Answer conditional GET and HEAD requests. The response is buffered and,
+// unless the handler set an 'ETag' header, an ETag is computed from the body
+// of 200 responses; a weak ETag (W/"...") if weak is true, otherwise a strong
+// one. 'If-None-Match' and 'If-Modified-Since' (using the 'Last-Modified' the
+// handler set) are then answered with 304 (Not Modified), and failed
+// 'If-Match' or 'If-Unmodified-Since' preconditions with 412 (Precondition Failed).
+// For HEAD the ETag must be set by the handler, as there is no body to hash.
+//
+// Requests with other methods are passed through, unless ETagValidators() is
+// used, in which case failed preconditions of PUT, PATCH, DELETE and POST are
+// answered with 412. Checking the preconditions in the middleware is not atomic
+// with the update, handlers that need that should call CheckPreconditions()
+// with the ETag of the resource they are about to modify. If the handler calls
+// Flush() the response is streamed as is.
+func ETag(weak bool, options ...ETagOption) canis.Middleware {
+	var config etagConfig
+	for _, option := range options {
+		option(&config)
+	}
+	return func(next canis.ContextHandler) canis.ContextHandler {
+		return canis.ContextHandlerFunc(func(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+			switch req.Method {
+			case "GET", "HEAD":
+			case "PUT", "PATCH", "DELETE", "POST":
+				if config.validators != nil && hasPreconditions(req) {
+					etag, lastModified := config.validators(ctx, req)
+					if evaluatePreconditions(req, etag, lastModified) == http.StatusPreconditionFailed {
+						canis.Error(resp, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+						return
+					}
+				}
+				next.ServeHTTP(ctx, resp, req)
+				return
+			default:
+				next.ServeHTTP(ctx, resp, req)
+				return
+			}
+
+			writer := &etagWriter{resp: resp, status: http.StatusOK}
+			next.ServeHTTP(ctx, canis.NewFilterWriter(resp, writer), req)
+			writer.finish(req, weak)
+		})
+	}
+}
+
+// The request headers evaluated by evaluatePreconditions()
+var conditionalHeaders = []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"}
+
+func hasPreconditions(req *http.Request) bool {
+	for _, name := range conditionalHeaders {
+		if req.Header.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate the conditional headers of the request against the current ETag
+// and modification time of the resource, either may be empty if unknown. Returns
+// true if a response was sent and the handler should return; 304 (Not
+// Modified) for GET or HEAD, or 412 (Precondition Failed) if a precondition
+// such as 'If-Match' failed, which for PUT, PATCH and DELETE means the client
+// is modifying an out of date copy.
+//
+//	pie, err := store.Get(ctx.ByName("id"))
+//	...
+//	if request.CheckPreconditions(resp, req, pie.ETag(), pie.Updated) {
+//		return
+//	}
+//	// Safe to update the pie
+//
+// For GET and HEAD the 'ETag' and 'Last-Modified' response headers are set.
+func CheckPreconditions(resp http.ResponseWriter, req *http.Request, etag string, lastModified time.Time) bool {
+	if req.Method == "GET" || req.Method == "HEAD" {
+		if etag != "" {
+			resp.Header().Set("ETag", etag)
+		}
+		if !lastModified.IsZero() {
+			resp.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+		}
+	}
+	switch evaluatePreconditions(req, etag, lastModified) {
+	case http.StatusNotModified:
+		writeNotModified(resp)
+		return true
+	case http.StatusPreconditionFailed:
+		canis.Error(resp, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+		return true
+	}
+	return false
+}
+
+// Returns 304, 412 or zero if the request should proceed, in the order of RFC 7232 section 6
+func evaluatePreconditions(req *http.Request, etag string, lastModified time.Time) int {
+	if match := req.Header.Get("If-Match"); match != "" {
+		if !matchETag(match, etag, false) {
+			return http.StatusPreconditionFailed
+		}
+	} else if since, ok := headerTime(req, "If-Unmodified-Since"); ok && !lastModified.IsZero() {
+		if lastModified.Truncate(time.Second).After(since) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	safe := req.Method == "GET" || req.Method == "HEAD"
+	if match := req.Header.Get("If-None-Match"); match != "" {
+		if matchETag(match, etag, true) {
+			if safe {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if since, ok := headerTime(req, "If-Modified-Since"); ok && safe && !lastModified.IsZero() {
+		if !lastModified.Truncate(time.Second).After(since) {
+			return http.StatusNotModified
+		}
+	}
+	return 0
+}
+
+func headerTime(req *http.Request, name string) (time.Time, bool) {
+	value := req.Header.Get(name)
+	if value == "" {
+		return time.Time{}, false
+	}
+	parsed, err := http.ParseTime(value)
+	return parsed, err == nil
+}
+
+// True if etag is in the list of entity tags or the list is '*'. Weak comparison ignores the 'W/' prefix,
+// strong comparison never matches a weak tag
+func matchETag(list, etag string, weak bool) bool {
+	if etag == "" {
+		return false
+	}
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+			continue
+		}
+		if candidate == etag && !strings.HasPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Send 304, keeping only the headers that describe the cached response
+func writeNotModified(resp http.ResponseWriter) {
+	header := resp.Header()
+	header.Del("Content-Type")
+	header.Del("Content-Length")
+	header.Del("Content-Encoding")
+	if header.Get("ETag") != "" {
+		header.Del("Last-Modified")
+	}
+	resp.WriteHeader(http.StatusNotModified)
+}
+
+// Buffers the response to compute the ETag, until the handler flushes
+type etagWriter struct {
+	resp        http.ResponseWriter
+	status      int
+	wroteHeader bool
+	buf         bytes.Buffer
+	// Set once the response is streamed
+	passThrough bool
+}
+
+func (self *etagWriter) WriteHeader(status int) {
+	if self.passThrough {
+		self.resp.WriteHeader(status)
+		return
+	}
+	if self.wroteHeader {
+		return
+	}
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		self.resp.WriteHeader(status)
+		return
+	}
+	self.wroteHeader = true
+	self.status = status
+}
+
+func (self *etagWriter) Write(buf []byte) (int, error) {
+	if self.passThrough {
+		return self.resp.Write(buf)
+	}
+	self.wroteHeader = true
+	return self.buf.Write(buf)
+}
+
+// Stop buffering, everything written so far is sent without an ETag
+func (self *etagWriter) Flush() {
+	if !self.passThrough {
+		self.passThrough = true
+		self.resp.WriteHeader(self.status)
+		self.resp.Write(self.buf.Bytes())
+		self.buf = bytes.Buffer{}
+	}
+	if flusher, ok := self.resp.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (self *etagWriter) Hijacked() {
+	self.passThrough = true
+}
+
+// Called once the handler returns
+func (self *etagWriter) finish(req *http.Request, weak bool) {
+	if self.passThrough {
+		return
+	}
+	if self.status == http.StatusOK {
+		header := self.resp.Header()
+		etag := header.Get("ETag")
+		if etag == "" && self.buf.Len() != 0 {
+			etag = computeETag(self.buf.Bytes(), weak)
+			header.Set("ETag", etag)
+		}
+		lastModified, _ := http.ParseTime(header.Get("Last-Modified"))
+
+		switch evaluatePreconditions(req, etag, lastModified) {
+		case http.StatusNotModified:
+			writeNotModified(self.resp)
+			return
+		case http.StatusPreconditionFailed:
+			header.Del("ETag")
+			header.Del("Last-Modified")
+			canis.Error(self.resp, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+			return
+		}
+	}
+	if !self.wroteHeader {
+		// Nothing was written, let the server send the default response
+		return
+	}
+	self.resp.WriteHeader(self.status)
+	self.resp.Write(self.buf.Bytes())
+}
+
+// Returns a quoted hash of the body, 'W/' prefixed if weak
+func computeETag(body []byte, weak bool) string {
+	hash := fnv.New64a()
+	hash.Write(body)
+	var sum [8]byte
+	tag := `"` + hex.EncodeToString(hash.Sum(sum[:0])) + `"`
+	if weak {
+		return "W/" + tag
+	}
+	return tag
+}
@@ -0,0 +1,195 @@
+package request_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/thrawn01/canis/request"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("ETag()", func() {
+	modified := time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	pie := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"flavor": "apple"}`))
+	}
+
+	It("should compute a strong or weak ETag from the body", func() {
+		resp := serve(newRequest("GET", "/pies/1", nil, nil), pie, request.ETag(false))
+		Expect(resp.Code).To(Equal(http.StatusOK))
+		Expect(resp.Header().Get("ETag")).To(MatchRegexp(`^"[0-9a-f]{16}"$`))
+		Expect(resp.Body.String()).To(Equal(`{"flavor": "apple"}`))
+		strong := resp.Header().Get("ETag")
+
+		resp = serve(newRequest("GET", "/pies/1", nil, nil), pie, request.ETag(true))
+		Expect(resp.Header().Get("ETag")).To(Equal("W/" + strong))
+	})
+
+	It("should answer If-None-Match with 304", func() {
+		etag := serve(newRequest("GET", "/pies/1", nil, nil), pie, request.ETag(false)).Header().Get("ETag")
+
+		resp := serve(newRequest("GET", "/pies/1", nil, map[string]string{"If-None-Match": `"other", ` + etag}), pie, request.ETag(false))
+		Expect(resp.Code).To(Equal(http.StatusNotModified))
+		Expect(resp.Body.String()).To(BeEmpty())
+		Expect(resp.Header().Get("ETag")).To(Equal(etag))
+		Expect(resp.Header().Get("Content-Type")).To(BeEmpty())
+
+		// Weak comparison is used for If-None-Match
+		resp = serve(newRequest("GET", "/pies/1", nil, map[string]string{"If-None-Match": "W/" + etag}), pie, request.ETag(false))
+		Expect(resp.Code).To(Equal(http.StatusNotModified))
+
+		resp = serve(newRequest("GET", "/pies/1", nil, map[string]string{"If-None-Match": `"other"`}), pie, request.ETag(false))
+		Expect(resp.Code).To(Equal(http.StatusOK))
+	})
+
+	It("should use the ETag and Last-Modified set by the handler", func() {
+		app := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"v2"`)
+			w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+			w.Write([]byte("pie"))
+		}
+		resp := serve(newRequest("GET", "/pies/1", nil, map[string]string{"If-None-Match": `"v2"`}), app, request.ETag(false))
+		Expect(resp.Code).To(Equal(http.StatusNotModified))
+
+		resp = serve(newRequest("GET", "/pies/1", nil, map[string]string{"If-Modified-Since": modified.Format(http.TimeFormat)}), app, request.ETag(false))
+		Expect(resp.Code).To(Equal(http.StatusNotModified))
+
+		resp = serve(newRequest("GET", "/pies/1", nil, map[string]string{"If-Modified-Since": modified.Add(-time.Hour).Format(http.TimeFormat)}), app, request.ETag(false))
+		Expect(resp.Code).To(Equal(http.StatusOK))
+		Expect(resp.Body.String()).To(Equal("pie"))
+
+		// If-None-Match takes precedence over If-Modified-Since
+		resp = serve(newRequest("GET", "/pies/1", nil, map[string]string{
+			"If-None-Match":     `"v1"`,
+			"If-Modified-Since": modified.Format(http.TimeFormat),
+		}), app, request.ETag(false))
+		Expect(resp.Code).To(Equal(http.StatusOK))
+	})
+
+	It("should answer failed If-Match with 412", func() {
+		resp := serve(newRequest("GET", "/pies/1", nil, map[string]string{"If-Match": `"other"`}), pie, request.ETag(false))
+		Expect(resp.Code).To(Equal(http.StatusPreconditionFailed))
+	})
+
+	It("should check unsafe methods against the validators", func() {
+		var updates, lookups int
+		update := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			updates++
+			w.WriteHeader(http.StatusNoContent)
+		}
+		validators := request.ETagValidators(func(ctx context.Context, r *http.Request) (string, time.Time) {
+			lookups++
+			return `"v2"`, modified
+		})
+
+		resp := serve(newRequest("PUT", "/pies/1", nil, map[string]string{"If-Match": `"v1"`}), update, request.ETag(false, validators))
+		Expect(resp.Code).To(Equal(http.StatusPreconditionFailed))
+		Expect(updates).To(Equal(0))
+
+		resp = serve(newRequest("DELETE", "/pies/1", nil, map[string]string{"If-None-Match": "*"}), update, request.ETag(false, validators))
+		Expect(resp.Code).To(Equal(http.StatusPreconditionFailed))
+
+		resp = serve(newRequest("PATCH", "/pies/1", nil, map[string]string{"If-Match": `"v2"`}), update, request.ETag(false, validators))
+		Expect(resp.Code).To(Equal(http.StatusNoContent))
+		Expect(updates).To(Equal(1))
+
+		// Only conditional requests look up the validators
+		resp = serve(newRequest("PUT", "/pies/1", nil, nil), update, request.ETag(false, validators))
+		Expect(resp.Code).To(Equal(http.StatusNoContent))
+		Expect(lookups).To(Equal(3))
+
+		// Without validators the handler checks the preconditions
+		resp = serve(newRequest("PUT", "/pies/1", nil, map[string]string{"If-Match": `"v1"`}), update, request.ETag(false))
+		Expect(resp.Code).To(Equal(http.StatusNoContent))
+	})
+
+	It("should answer HEAD with the ETag set by the handler", func() {
+		head := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"v2"`)
+		}
+		resp := serve(newRequest("HEAD", "/pies/1", nil, nil), head, request.ETag(false))
+		Expect(resp.Code).To(Equal(http.StatusOK))
+		Expect(resp.Header().Get("ETag")).To(Equal(`"v2"`))
+
+		resp = serve(newRequest("HEAD", "/pies/1", nil, map[string]string{"If-None-Match": `"v2"`}), head, request.ETag(false))
+		Expect(resp.Code).To(Equal(http.StatusNotModified))
+	})
+
+	It("should not touch error responses or other methods", func() {
+		resp := serve(newRequest("GET", "/pies/1", nil, map[string]string{"If-None-Match": "*"}), func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "no pie", http.StatusNotFound)
+		}, request.ETag(false))
+		Expect(resp.Code).To(Equal(http.StatusNotFound))
+		Expect(resp.Header().Get("ETag")).To(BeEmpty())
+
+		resp = serve(newRequest("POST", "/pies/1", nil, nil), pie, request.ETag(false))
+		Expect(resp.Header().Get("ETag")).To(BeEmpty())
+	})
+
+	It("should stream the response once flushed", func() {
+		resp := serve(newRequest("GET", "/pies/1", nil, nil), func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("apple "))
+			w.(http.Flusher).Flush()
+			w.Write([]byte("pie"))
+		}, request.ETag(false))
+		Expect(resp.Flushed).To(BeTrue())
+		Expect(resp.Header().Get("ETag")).To(BeEmpty())
+		Expect(resp.Body.String()).To(Equal("apple pie"))
+	})
+})
+
+var _ = Describe("CheckPreconditions()", func() {
+	modified := time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	check := func(method string, headers map[string]string) (*httptest.ResponseRecorder, bool) {
+		resp := httptest.NewRecorder()
+		return resp, request.CheckPreconditions(resp, newRequest(method, "/pies/1", nil, headers), `"v2"`, modified)
+	}
+
+	It("should reject updates to an out of date copy with 412", func() {
+		for _, method := range []string{"PUT", "PATCH", "DELETE"} {
+			resp, done := check(method, map[string]string{"If-Match": `"v1"`})
+			Expect(done).To(BeTrue())
+			Expect(resp.Code).To(Equal(http.StatusPreconditionFailed))
+
+			_, done = check(method, map[string]string{"If-Match": `"v1", "v2"`})
+			Expect(done).To(BeFalse())
+
+			// Strong comparison is used for If-Match
+			_, done = check(method, map[string]string{"If-Match": `W/"v2"`})
+			Expect(done).To(BeTrue())
+
+			_, done = check(method, map[string]string{"If-Unmodified-Since": modified.Add(-time.Hour).Format(http.TimeFormat)})
+			Expect(done).To(BeTrue())
+
+			_, done = check(method, map[string]string{"If-Unmodified-Since": modified.Format(http.TimeFormat)})
+			Expect(done).To(BeFalse())
+
+			// Create only if it does not exist
+			_, done = check(method, map[string]string{"If-None-Match": "*"})
+			Expect(done).To(BeTrue())
+		}
+	})
+
+	It("should proceed without conditional headers", func() {
+		resp, done := check("PUT", nil)
+		Expect(done).To(BeFalse())
+		Expect(resp.Header().Get("ETag")).To(BeEmpty())
+	})
+
+	It("should answer GET with 304 and set the validators", func() {
+		resp, done := check("GET", map[string]string{"If-None-Match": `"v2"`})
+		Expect(done).To(BeTrue())
+		Expect(resp.Code).To(Equal(http.StatusNotModified))
+		Expect(resp.Header().Get("ETag")).To(Equal(`"v2"`))
+
+		resp, done = check("GET", nil)
+		Expect(done).To(BeFalse())
+		Expect(resp.Header().Get("Last-Modified")).To(Equal("Sun, 01 May 2016 12:00:00 GMT"))
+	})
+})
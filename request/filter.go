@@ -0,0 +1,95 @@
+package request
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// Decides which requests are written to the access log. Server errors (5XX)
+// and requests slower than the slow threshold are always logged.
+type logFilter struct {
+	skipPaths  map[string]bool
+	skipRoutes map[string]bool
+	// Status classes to log (2 for 2XX, 4 for 4XX), all if nil
+	classes    map[int]bool
+	sampleRate float64
+	slow       time.Duration
+}
+
+// Do not log requests for these URL paths, such as '/healthz'. Server errors and slow requests are still logged.
+func SkipPaths(paths ...string) LoggerOption {
+	return func(self *RequestLogger) {
+		if self.filter.skipPaths == nil {
+			self.filter.skipPaths = make(map[string]bool)
+		}
+		for _, path := range paths {
+			self.filter.skipPaths[path] = true
+		}
+	}
+}
+
+// Do not log requests matched by these Router patterns, such as '/pies/:id'.
+// Server errors and slow requests are still logged.
+func SkipRoutes(patterns ...string) LoggerOption {
+	return func(self *RequestLogger) {
+		if self.filter.skipRoutes == nil {
+			self.filter.skipRoutes = make(map[string]bool)
+		}
+		for _, pattern := range patterns {
+			self.filter.skipRoutes[pattern] = true
+		}
+	}
+}
+
+// Only log responses in these status classes, where 2 is 2XX, 4 is 4XX and so
+// on. Server errors and slow requests are always logged.
+//
+//	request.Logger(log, request.StatusClasses(4))
+func StatusClasses(classes ...int) LoggerOption {
+	return func(self *RequestLogger) {
+		self.filter.classes = make(map[int]bool, len(classes))
+		for _, class := range classes {
+			self.filter.classes[class] = true
+		}
+	}
+}
+
+// Log only a fraction of the successful (1XX, 2XX and 3XX) requests, a rate of
+// 0.1 logs about one in ten. Panics unless the rate is between 0 and 1.
+func SampleRate(rate float64) LoggerOption {
+	if rate < 0 || rate > 1 {
+		panic(fmt.Sprintf("sample rate '%v' must be between 0 and 1", rate))
+	}
+	return func(self *RequestLogger) {
+		self.filter.sampleRate = rate
+	}
+}
+
+// Always log requests that took at least the threshold to serve, regardless of any other filter
+func SlowThreshold(threshold time.Duration) LoggerOption {
+	return func(self *RequestLogger) {
+		self.filter.slow = threshold
+	}
+}
+
+func (self *logFilter) shouldLog(entry *logEntry) bool {
+	status := entry.resp.Status()
+	if status >= http.StatusInternalServerError {
+		return true
+	}
+	if self.slow > 0 && entry.duration >= self.slow {
+		return true
+	}
+	if self.skipPaths[entry.req.URL.Path] || self.skipRoutes[entry.route] {
+		return false
+	}
+	if self.classes != nil && !self.classes[status/100] {
+		return false
+	}
+	if status < http.StatusBadRequest && self.sampleRate < 1 && rand.Float64() >= self.sampleRate {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,263 @@
+package request
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thrawn01/canis"
+)
+
+const (
+	// Apache Common Log Format
+	CommonLogFormat = `%h %l %u %t "%r" %s %b`
+	// Apache Combined Log Format, the Common Log Format plus the referer and user agent
+	CombinedLogFormat = `%h %l %u %t "%r" %s %b "%{Referer}i" "%{User-Agent}i"`
+	// The format used by Logger() and ErrorLogger() unless another is provided. Lines
+	// in the default format end with the latency and time to first byte, the timing
+	// segments and the id assigned by RequestID() if any, e.g. 'latency_ms=1.2
+	// ttfb_ms=0.8 timings=db=0.5ms request_id=...'. A custom format must include the
+	// '%D', '%F', '%S' and '%L' tokens instead
+	DefaultLogFormat = `%h %l %u %t %m "%U%q" %H %s %B`
+)
+
+// The layout used by the '%t' token, as used by Apache
+const apacheTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// Everything known about a request once it has completed
+type logEntry struct {
+	req       *http.Request
+	resp      canis.ResponseWriter
+	start     time.Time
+	duration  time.Duration
+	firstByte time.Duration
+	timings   *timings
+	// The client resolved by RealIP(), if in the chain
+	client *ClientInfo
+	// The principal authenticated by later middleware
+	principal *principalRecord
+	// The route pattern matched by the Router, only collected for structured logs or SkipRoutes()
+	route string
+	// The body of a non 2XX response if captured
+	errorMsg []byte
+}
+
+type logToken func(*bytes.Buffer, *logEntry)
+
+// A parsed log format, ready to write entries
+type logFormat []logToken
+
+func (self logFormat) write(buf *bytes.Buffer, entry *logEntry) {
+	for _, token := range self {
+		token(buf, entry)
+	}
+}
+
+// Parse a log format template, see Format() for the supported tokens
+func parseLogFormat(format string) (logFormat, error) {
+	var result logFormat
+	var literal []byte
+
+	flush := func() {
+		if len(literal) != 0 {
+			text := string(literal)
+			result = append(result, func(buf *bytes.Buffer, _ *logEntry) {
+				buf.WriteString(text)
+			})
+			literal = nil
+		}
+	}
+
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			literal = append(literal, format[i])
+			continue
+		}
+		i++
+		if i == len(format) {
+			return nil, fmt.Errorf("log format '%s' ends with an incomplete token", format)
+		}
+		if format[i] == '%' {
+			literal = append(literal, '%')
+			continue
+		}
+
+		// Header and unit tokens; %{Name}i, %{Name}o or %{UNIT}T
+		if format[i] == '{' {
+			end := strings.IndexByte(format[i:], '}')
+			if end == -1 || i+end+1 >= len(format) {
+				return nil, fmt.Errorf("log format '%s' has an unterminated %%{...} token", format)
+			}
+			name := format[i+1 : i+end]
+			kind := format[i+end+1]
+			i += end + 1
+
+			token, err := braceToken(name, kind)
+			if err != nil {
+				return nil, err
+			}
+			flush()
+			result = append(result, token)
+			continue
+		}
+
+		token, ok := logTokens[format[i]]
+		if !ok {
+			return nil, fmt.Errorf("log format '%s' has unknown token '%%%c'", format, format[i])
+		}
+		flush()
+		result = append(result, token)
+	}
+	flush()
+	return result, nil
+}
+
+func braceToken(name string, kind byte) (logToken, error) {
+	switch kind {
+	case 'T':
+		unit, ok := durationUnits[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown duration unit in '%%{%s}T'; expected 's', 'ms' or 'us'", name)
+		}
+		return func(buf *bytes.Buffer, entry *logEntry) {
+			writeInt(buf, int64(entry.duration/unit))
+		}, nil
+	case 'i':
+		name = http.CanonicalHeaderKey(name)
+		return func(buf *bytes.Buffer, entry *logEntry) {
+			writeOrDash(buf, entry.req.Header.Get(name))
+		}, nil
+	case 'o':
+		name = http.CanonicalHeaderKey(name)
+		return func(buf *bytes.Buffer, entry *logEntry) {
+			writeOrDash(buf, entry.resp.Header().Get(name))
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown token '%%{%s}%c'; expected 'i', 'o' or 'T'", name, kind)
+}
+
+var logTokens = map[byte]logToken{
+	'h': func(buf *bytes.Buffer, entry *logEntry) {
+		buf.WriteString(entry.remoteHost())
+	},
+	'l': func(buf *bytes.Buffer, _ *logEntry) {
+		buf.WriteByte('-')
+	},
+	'u': func(buf *bytes.Buffer, entry *logEntry) {
+		writeOrDash(buf, entry.user())
+	},
+	't': func(buf *bytes.Buffer, entry *logEntry) {
+		var scratch [64]byte
+		buf.WriteByte('[')
+		buf.Write(entry.start.AppendFormat(scratch[:0], apacheTimeLayout))
+		buf.WriteByte(']')
+	},
+	'r': func(buf *bytes.Buffer, entry *logEntry) {
+		buf.WriteString(entry.req.Method)
+		buf.WriteByte(' ')
+		buf.WriteString(entry.req.URL.RequestURI())
+		buf.WriteByte(' ')
+		buf.WriteString(entry.req.Proto)
+	},
+	'L': func(buf *bytes.Buffer, entry *logEntry) {
+		writeOrDash(buf, entry.requestID())
+	},
+	'm': func(buf *bytes.Buffer, entry *logEntry) {
+		buf.WriteString(entry.req.Method)
+	},
+	'U': func(buf *bytes.Buffer, entry *logEntry) {
+		buf.WriteString(entry.req.URL.EscapedPath())
+	},
+	'q': func(buf *bytes.Buffer, entry *logEntry) {
+		if entry.req.URL.RawQuery != "" {
+			buf.WriteByte('?')
+			buf.WriteString(entry.req.URL.RawQuery)
+		}
+	},
+	'H': func(buf *bytes.Buffer, entry *logEntry) {
+		buf.WriteString(entry.req.Proto)
+	},
+	's': func(buf *bytes.Buffer, entry *logEntry) {
+		writeInt(buf, int64(entry.resp.Status()))
+	},
+	'b': func(buf *bytes.Buffer, entry *logEntry) {
+		if entry.resp.Size() == 0 {
+			buf.WriteByte('-')
+			return
+		}
+		writeInt(buf, int64(entry.resp.Size()))
+	},
+	'B': func(buf *bytes.Buffer, entry *logEntry) {
+		writeInt(buf, int64(entry.resp.Size()))
+	},
+	'D': func(buf *bytes.Buffer, entry *logEntry) {
+		writeInt(buf, int64(entry.duration/time.Microsecond))
+	},
+	'T': func(buf *bytes.Buffer, entry *logEntry) {
+		writeInt(buf, int64(entry.duration/time.Second))
+	},
+	'F': func(buf *bytes.Buffer, entry *logEntry) {
+		writeInt(buf, int64(entry.firstByte/time.Microsecond))
+	},
+	'S': func(buf *bytes.Buffer, entry *logEntry) {
+		entry.timings.writeLog(buf)
+	},
+}
+
+// Units accepted by the %{UNIT}T token
+var durationUnits = map[string]time.Duration{
+	"s":  time.Second,
+	"ms": time.Millisecond,
+	"us": time.Microsecond,
+}
+
+func writeInt(buf *bytes.Buffer, value int64) {
+	var scratch [20]byte
+	buf.Write(strconv.AppendInt(scratch[:0], value, 10))
+}
+
+func writeOrDash(buf *bytes.Buffer, value string) {
+	if value == "" {
+		buf.WriteByte('-')
+		return
+	}
+	buf.WriteString(value)
+}
+
+// Returns the address of the client resolved by RealIP() or the address of the connection
+func (self *logEntry) remoteHost() string {
+	if self.client != nil {
+		return self.client.IP
+	}
+	return remoteHost(self.req)
+}
+
+// Returns the id assigned by RequestID(), which is echoed in the response. The id the client
+// sent is never logged unless RequestID() accepted it, so clients can not forge log entries
+func (self *logEntry) requestID() string {
+	if id := self.resp.Header().Get(RequestIDHeader); validRequestID(id) {
+		return id
+	}
+	return ""
+}
+
+// Returns the address of the connection without the port
+func remoteHost(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// Returns the ID of the principal set with WithPrincipal(), or an empty string
+func (self *logEntry) user() string {
+	if self.principal != nil && self.principal.principal != nil {
+		return self.principal.principal.ID
+	}
+	return ""
+}
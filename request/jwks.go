@@ -0,0 +1,275 @@
+package request
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Returned by a KeySet which has no key with the id the token was signed with
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// The keys JWT() verifies tokens with
+type KeySet interface {
+	// Returns the key with the id ('kid' in the token header), or the only key if
+	// the id is empty. Keys are []byte for HS256, *rsa.PublicKey for RS256 and
+	// *ecdsa.PublicKey for ES256.
+	Key(kid string) (interface{}, error)
+}
+
+// A fixed set of keys by key id
+//
+//	request.JWT(request.Keys{"": []byte(secret)})
+type Keys map[string]interface{}
+
+func (self Keys) Key(kid string) (interface{}, error) {
+	if key, ok := self[kid]; ok {
+		return checkKey(kid, key)
+	}
+	if kid == "" && len(self) == 1 {
+		for id, key := range self {
+			return checkKey(id, key)
+		}
+	}
+	return nil, ErrUnknownKey
+}
+
+// ES256 is only defined for keys on the P-256 curve
+func checkKey(kid string, key interface{}) (interface{}, error) {
+	if public, ok := key.(*ecdsa.PublicKey); ok && public.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("key '%s' is not on the P-256 curve", kid)
+	}
+	return key, nil
+}
+
+// The members of a JSON Web Key (RFC 7517) used for signature verification
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Parse a JSON Web Key Set ({"keys": [...]}). Symmetric ('oct'), RSA and P-256
+// EC keys are supported, keys of other types or not for signing are skipped.
+func ParseJWKS(data []byte) (Keys, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS; %s", err)
+	}
+
+	keys := make(Keys, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		var key interface{}
+		var err error
+		switch jwk.Kty {
+		case "oct":
+			key, err = decodeSegment(jwk.K)
+		case "RSA":
+			key, err = rsaKey(jwk)
+		case "EC":
+			key, err = ecKey(jwk)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS key '%s'; %s", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+func rsaKey(jwk jsonWebKey) (*rsa.PublicKey, error) {
+	n, err := decodeSegment(jwk.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := decodeSegment(jwk.E)
+	if err != nil {
+		return nil, err
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 2 || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid RSA modulus or exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
+
+func ecKey(jwk jsonWebKey) (*ecdsa.PublicKey, error) {
+	if jwk.Crv != "P-256" {
+		return nil, fmt.Errorf("unsupported curve '%s'", jwk.Crv)
+	}
+	x, err := decodeSegment(jwk.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := decodeSegment(jwk.Y)
+	if err != nil {
+		return nil, err
+	}
+	key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	if !key.Curve.IsOnCurve(key.X, key.Y) {
+		return nil, errors.New("point is not on the curve")
+	}
+	return key, nil
+}
+
+// Decode base64url, with or without padding
+func decodeSegment(segment string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+}
+
+// Returns the keys in a JSON Web Key Set file, see ParseJWKS()
+func JWKSFile(path string) (Keys, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
+
+// Unknown key ids cause the keys to be fetched early at most this often, unless the refresh interval is shorter
+var jwksMinInterval = 10 * time.Second
+
+// Returns a KeySet that fetches a JSON Web Key Set from the url, such as
+// 'https://example.com/.well-known/jwks.json', when first used and again
+// once refresh has passed. Only the first use waits for the keys, later
+// fetches run in the background while the keys already fetched are used, and
+// concurrent requests share a single fetch. A token signed with an unknown key
+// waits for an early fetch, as the keys may have been rotated. If a fetch fails
+// the keys already fetched are used until a fetch succeeds.
+func NewRemoteKeySet(url string, refresh time.Duration) *RemoteKeySet {
+	minInterval := jwksMinInterval
+	if refresh < minInterval {
+		minInterval = refresh
+	}
+	return &RemoteKeySet{
+		url:         url,
+		refresh:     refresh,
+		minInterval: minInterval,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type RemoteKeySet struct {
+	url         string
+	refresh     time.Duration
+	minInterval time.Duration
+	client      *http.Client
+
+	mutex sync.Mutex
+	keys  Keys
+	// When the keys were last fetched, and when a fetch was last attempted
+	fetched   time.Time
+	attempted time.Time
+	// Why the last fetch failed
+	err error
+	// Closed once the fetch in progress completes, nil if there is none
+	fetching chan struct{}
+}
+
+func (self *RemoteKeySet) Key(kid string) (interface{}, error) {
+	now := time.Now()
+	self.mutex.Lock()
+	if self.keys == nil && (self.fetching != nil || self.mayFetch(now)) {
+		// Nothing to use until the first fetch completes
+		done := self.startFetch(now)
+		self.mutex.Unlock()
+		<-done
+		self.mutex.Lock()
+	} else if self.keys != nil && now.Sub(self.fetched) >= self.refresh && self.mayFetch(now) {
+		self.startFetch(now)
+	}
+	keys, err := self.keys, self.err
+	self.mutex.Unlock()
+
+	if keys == nil {
+		return nil, err
+	}
+	key, err := keys.Key(kid)
+	if err != ErrUnknownKey {
+		return key, err
+	}
+
+	// The keys may have been rotated, wait for a fetch if one may be made
+	self.mutex.Lock()
+	if self.fetching == nil && !self.mayFetch(now) {
+		self.mutex.Unlock()
+		return nil, err
+	}
+	done := self.startFetch(now)
+	self.mutex.Unlock()
+	<-done
+
+	self.mutex.Lock()
+	keys = self.keys
+	self.mutex.Unlock()
+	return keys.Key(kid)
+}
+
+func (self *RemoteKeySet) mayFetch(now time.Time) bool {
+	return self.attempted.IsZero() || now.Sub(self.attempted) >= self.minInterval
+}
+
+// Start fetching the keys unless a fetch is in progress, returns a channel that is
+// closed once the fetch completes. The mutex must be held.
+func (self *RemoteKeySet) startFetch(now time.Time) chan struct{} {
+	if self.fetching == nil {
+		self.fetching = make(chan struct{})
+		self.attempted = now
+		go self.fetch(self.fetching)
+	}
+	return self.fetching
+}
+
+func (self *RemoteKeySet) fetch(done chan struct{}) {
+	keys, err := self.get()
+
+	self.mutex.Lock()
+	if err != nil {
+		self.err = fmt.Errorf("unable to fetch JWKS from '%s'; %s", self.url, err)
+	} else {
+		self.keys, self.fetched, self.err = keys, time.Now(), nil
+	}
+	self.fetching = nil
+	self.mutex.Unlock()
+	close(done)
+}
+
+func (self *RemoteKeySet) get() (Keys, error) {
+	resp, err := self.client.Get(self.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
@@ -0,0 +1,361 @@
+package request
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thrawn01/canis"
+	"golang.org/x/net/context"
+)
+
+// How far the clocks of the token issuer and the server may differ, unless ClockSkew() is used
+var DefaultClockSkew = time.Minute
+
+// The registered claims of a validated JSON Web Token
+type Claims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	ID        string
+	// From the space separated 'scope' claim, or the 'scp' claim used by some issuers
+	Scopes []string
+	// Every claim in the token
+	Raw map[string]interface{}
+}
+
+// True if the token was granted the scope
+func (self *Claims) HasScope(scope string) bool {
+	for _, granted := range self.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type claimsKey struct{}
+
+// Returns the claims of the token validated by JWT(), or nil
+func GetClaims(ctx context.Context) *Claims {
+	claims, _ := ctx.Value(claimsKey{}).(*Claims)
+	return claims
+}
+
+type JWTOption func(*jwtValidator)
+
+// Only accept tokens issued for one of the audiences ('aud')
+func Audience(audiences ...string) JWTOption {
+	return func(self *jwtValidator) {
+		self.audiences = audiences
+	}
+}
+
+// Only accept tokens from one of the issuers ('iss')
+func Issuer(issuers ...string) JWTOption {
+	return func(self *jwtValidator) {
+		self.issuers = issuers
+	}
+}
+
+// Allow for clocks that differ by up to skew when checking 'exp' and 'nbf'
+func ClockSkew(skew time.Duration) JWTOption {
+	return func(self *jwtValidator) {
+		self.skew = skew
+	}
+}
+
+// Reject tokens without an expiry ('exp'), which are otherwise valid forever.
+// Required by default, pass false to accept tokens that never expire.
+func RequireExpiry(required bool) JWTOption {
+	return func(self *jwtValidator) {
+		self.requireExpiry = required
+	}
+}
+
+// Require a valid JSON Web Token in the 'Authorization: Bearer' header, signed
+// with HS256, RS256 or ES256 by one of the keys. The algorithm must match the
+// type of the key, so a token can not choose to be verified with a public key
+// as an HMAC secret. ES256 keys must be on the P-256 curve. Tokens without an
+// expiry unless RequireExpiry(false) is used, expired tokens or those not valid
+// yet ('exp' and 'nbf'), allowing for ClockSkew(), and tokens for other
+// audiences or issuers when Audience() or Issuer() are used are rejected.
+//
+// Requests without a valid token are rejected with 401 (Unauthorized) and a
+// 'WWW-Authenticate' challenge as described by RFC 6750, or 500 (Internal
+// Server Error) if the keys could not be loaded. The claims are available to
+// later handlers through GetClaims(), use RequireScopes() to limit routes to
+// tokens with a scope. The subject is the principal returned by GetPrincipal(),
+// with the roles in the 'roles' claim.
+func JWT(keys KeySet, options ...JWTOption) canis.Middleware {
+	validator := newJWTValidator(keys, options)
+	return func(next canis.ContextHandler) canis.ContextHandler {
+		return canis.ContextHandlerFunc(func(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+			token := bearerToken(req)
+			if token == "" {
+				resp.Header().Set("WWW-Authenticate", "Bearer")
+				canis.Error(resp, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			claims, err := validator.validate(token)
+			if _, ok := err.(*keySetError); ok {
+				canis.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			if err != nil {
+				description := strings.Replace(err.Error(), `"`, `'`, -1)
+				resp.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="`+description+`"`)
+				canis.Error(resp, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			ctx = context.WithValue(ctx, claimsKey{}, claims)
+			principal := &Principal{ID: claims.Subject, Method: "jwt", Roles: stringList(claims.Raw["roles"]), Scopes: claims.Scopes}
+			next.ServeHTTP(WithPrincipal(ctx, principal), resp, req)
+		})
+	}
+}
+
+// Validate the token as JWT() would, for tokens that do not arrive in the 'Authorization' header
+func ValidateJWT(token string, keys KeySet, options ...JWTOption) (*Claims, error) {
+	return newJWTValidator(keys, options).validate(token)
+}
+
+// Only allow requests with a token granted all the scopes, must come after JWT()
+// in the chain. Usually used on a route with canis.Use().
+//
+//	router.DELETE("/pies/:id", deletePie, canis.Use(request.RequireScopes("pies:write")))
+//
+// Tokens without a scope are rejected with 403 (Forbidden), requests without a token with 401 (Unauthorized).
+func RequireScopes(scopes ...string) canis.Middleware {
+	return func(next canis.ContextHandler) canis.ContextHandler {
+		return canis.ContextHandlerFunc(func(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+			claims := GetClaims(ctx)
+			if claims == nil {
+				resp.Header().Set("WWW-Authenticate", "Bearer")
+				canis.Error(resp, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			for _, scope := range scopes {
+				if !claims.HasScope(scope) {
+					resp.Header().Set("WWW-Authenticate",
+						fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, strings.Join(scopes, " ")))
+					canis.Error(resp, "token is missing scope '"+scope+"'", http.StatusForbidden)
+					return
+				}
+			}
+			next.ServeHTTP(ctx, resp, req)
+		})
+	}
+}
+
+func bearerToken(req *http.Request) string {
+	header := req.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// The keys could not be loaded, which is not the fault of the token
+type keySetError struct {
+	err error
+}
+
+func (self *keySetError) Error() string {
+	return self.err.Error()
+}
+
+type jwtValidator struct {
+	keys          KeySet
+	audiences     []string
+	issuers       []string
+	skew          time.Duration
+	requireExpiry bool
+}
+
+func newJWTValidator(keys KeySet, options []JWTOption) *jwtValidator {
+	validator := &jwtValidator{keys: keys, skew: DefaultClockSkew, requireExpiry: true}
+	for _, option := range options {
+		option(validator)
+	}
+	return validator
+}
+
+func (self *jwtValidator) validate(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJSONSegment(parts[0], &header); err != nil {
+		return nil, errors.New("malformed token header")
+	}
+	signature, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+	key, err := self.keys.Key(header.Kid)
+	if err == ErrUnknownKey {
+		return nil, err
+	}
+	if err != nil {
+		return nil, &keySetError{err}
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var raw map[string]interface{}
+	if err := decodeJSONSegment(parts[1], &raw); err != nil {
+		return nil, errors.New("malformed token claims")
+	}
+	claims, err := parseClaims(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if self.requireExpiry && claims.ExpiresAt.IsZero() {
+		return nil, errors.New("token has no expiry")
+	}
+	if !claims.ExpiresAt.IsZero() && now.After(claims.ExpiresAt.Add(self.skew)) {
+		return nil, errors.New("token is expired")
+	}
+	if !claims.NotBefore.IsZero() && now.Add(self.skew).Before(claims.NotBefore) {
+		return nil, errors.New("token is not valid yet")
+	}
+	if len(self.issuers) != 0 && !containsAny(self.issuers, claims.Issuer) {
+		return nil, errors.New("token has an unexpected issuer")
+	}
+	if len(self.audiences) != 0 && !containsAny(self.audiences, claims.Audience...) {
+		return nil, errors.New("token is not for this audience")
+	}
+	return claims, nil
+}
+
+// Verify the signature with the key, which must be of the type the algorithm uses
+func verifySignature(alg string, key interface{}, signed string, signature []byte) error {
+	invalid := errors.New("invalid token signature")
+	hash := sha256.Sum256([]byte(signed))
+
+	switch alg {
+	case "HS256":
+		secret, ok := key.([]byte)
+		if !ok {
+			return invalid
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return invalid
+		}
+	case "RS256":
+		public, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(public, crypto.SHA256, hash[:], signature) != nil {
+			return invalid
+		}
+	case "ES256":
+		public, ok := key.(*ecdsa.PublicKey)
+		// The signature is r and s, 32 bytes each
+		if !ok || public.Curve != elliptic.P256() || len(signature) != 64 {
+			return invalid
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(public, hash[:], r, s) {
+			return invalid
+		}
+	default:
+		return fmt.Errorf("unsupported token algorithm '%s'", alg)
+	}
+	return nil
+}
+
+func decodeJSONSegment(segment string, value interface{}) error {
+	data, err := decodeSegment(segment)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(value)
+}
+
+func parseClaims(raw map[string]interface{}) (*Claims, error) {
+	claims := &Claims{Raw: raw}
+	claims.Issuer, _ = raw["iss"].(string)
+	claims.Subject, _ = raw["sub"].(string)
+	claims.ID, _ = raw["jti"].(string)
+	claims.Audience = stringList(raw["aud"])
+
+	if scope, ok := raw["scope"].(string); ok {
+		claims.Scopes = strings.Fields(scope)
+	} else if scp, ok := raw["scp"].(string); ok {
+		claims.Scopes = strings.Fields(scp)
+	} else {
+		claims.Scopes = stringList(raw["scp"])
+	}
+
+	for name, field := range map[string]*time.Time{"exp": &claims.ExpiresAt, "nbf": &claims.NotBefore, "iat": &claims.IssuedAt} {
+		value, ok := raw[name]
+		if !ok {
+			continue
+		}
+		number, ok := value.(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("token claim '%s' is not a number", name)
+		}
+		seconds, err := number.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("token claim '%s' is not a number", name)
+		}
+		*field = time.Unix(int64(seconds), 0)
+	}
+	return claims, nil
+}
+
+// Returns a claim that is either a string or a list of strings as a list
+func stringList(value interface{}) []string {
+	switch value := value.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		result := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// True if any of the values is one of the accepted
+func containsAny(accepted []string, values ...string) bool {
+	for _, value := range values {
+		for _, candidate := range accepted {
+			if value == candidate {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,305 @@
+package request_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/thrawn01/canis"
+	"github.com/thrawn01/canis/request"
+	"golang.org/x/net/context"
+)
+
+// Returns a token for the claims, signed with the key for the algorithm
+func signJWT(alg, kid string, key interface{}, claims map[string]interface{}) string {
+	encode := func(value interface{}) string {
+		data, _ := json.Marshal(value)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	signed := encode(header) + "." + encode(claims)
+	hash := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	case *ecdsa.PrivateKey:
+		r, s, _ := ecdsa.Sign(rand.Reader, key, hash[:])
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func jwksFor(keys map[string]interface{}) []byte {
+	encode := func(i *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(i.Bytes())
+	}
+	var set []map[string]string
+	for kid, key := range keys {
+		switch key := key.(type) {
+		case []byte:
+			set = append(set, map[string]string{"kty": "oct", "kid": kid, "k": base64.RawURLEncoding.EncodeToString(key)})
+		case *rsa.PublicKey:
+			set = append(set, map[string]string{"kty": "RSA", "kid": kid, "use": "sig",
+				"n": encode(key.N), "e": encode(big.NewInt(int64(key.E)))})
+		case *ecdsa.PublicKey:
+			set = append(set, map[string]string{"kty": "EC", "kid": kid, "crv": "P-256",
+				"x": encode(key.X), "y": encode(key.Y)})
+		}
+	}
+	data, _ := json.Marshal(map[string]interface{}{"keys": set})
+	return data
+}
+
+var _ = Describe("JWT()", func() {
+	secret := []byte("pie-secret")
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	keys := request.Keys{"hmac": secret, "rsa": &rsaKey.PublicKey, "ec": &ecKey.PublicKey}
+
+	var claims *request.Claims
+	var principal *request.Principal
+
+	record := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		claims = request.GetClaims(ctx)
+		principal = request.GetPrincipal(ctx)
+	}
+
+	bearer := func(token string) *http.Request {
+		req, _ := http.NewRequest("GET", "/pies", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return req
+	}
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"sub": "joe", "iss": "https://auth.example.com", "aud": "pies",
+			"exp": time.Now().Add(time.Hour).Unix(), "scope": "pies:read pies:write", "roles": []string{"baker"},
+		}
+	}
+
+	BeforeEach(func() {
+		claims, principal = nil, nil
+	})
+
+	It("should accept tokens signed with HS256, RS256 and ES256", func() {
+		for _, token := range []string{
+			signJWT("HS256", "hmac", secret, valid()),
+			signJWT("RS256", "rsa", rsaKey, valid()),
+			signJWT("ES256", "ec", ecKey, valid()),
+		} {
+			resp := serve(bearer(token), record, request.JWT(keys))
+			Expect(resp.Code).To(Equal(http.StatusOK))
+			Expect(claims.Subject).To(Equal("joe"))
+			Expect(claims.Issuer).To(Equal("https://auth.example.com"))
+			Expect(claims.Audience).To(Equal([]string{"pies"}))
+			Expect(claims.Scopes).To(Equal([]string{"pies:read", "pies:write"}))
+			Expect(principal).To(Equal(&request.Principal{ID: "joe", Method: "jwt",
+				Roles: []string{"baker"}, Scopes: []string{"pies:read", "pies:write"}}))
+		}
+	})
+
+	It("should reject missing, malformed and forged tokens", func() {
+		resp := serve(bearer(""), record, request.JWT(keys))
+		Expect(resp.Code).To(Equal(http.StatusUnauthorized))
+		Expect(resp.Header().Get("WWW-Authenticate")).To(Equal("Bearer"))
+
+		other, _ := rsa.GenerateKey(rand.Reader, 2048)
+		for _, token := range []string{
+			"not.a-token",
+			signJWT("HS256", "hmac", []byte("wrong"), valid()),
+			signJWT("RS256", "rsa", other, valid()),
+			signJWT("HS256", "unknown", secret, valid()),
+			signJWT("none", "hmac", secret, valid()),
+		} {
+			resp := serve(bearer(token), record, request.JWT(keys))
+			Expect(resp.Code).To(Equal(http.StatusUnauthorized), token)
+			Expect(resp.Header().Get("WWW-Authenticate")).To(HavePrefix(`Bearer error="invalid_token"`))
+			Expect(claims).To(BeNil())
+		}
+	})
+
+	It("should not verify a token with a key of another type", func() {
+		// Sign with the public key as the HMAC secret, the RSA key id must not accept HS256
+		public := jwksFor(map[string]interface{}{"rsa": &rsaKey.PublicKey})
+		Expect(serve(bearer(signJWT("HS256", "rsa", public, valid())), record, request.JWT(keys)).Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should check exp and nbf allowing for clock skew", func() {
+		expired := valid()
+		expired["exp"] = time.Now().Add(-30 * time.Second).Unix()
+		Expect(serve(bearer(signJWT("HS256", "hmac", secret, expired)), record, request.JWT(keys)).Code).To(Equal(http.StatusOK))
+		resp := serve(bearer(signJWT("HS256", "hmac", secret, expired)), record, request.JWT(keys, request.ClockSkew(0)))
+		Expect(resp.Code).To(Equal(http.StatusUnauthorized))
+		Expect(resp.Header().Get("WWW-Authenticate")).To(ContainSubstring(`error_description="token is expired"`))
+
+		early := valid()
+		early["nbf"] = time.Now().Add(30 * time.Second).Unix()
+		Expect(serve(bearer(signJWT("HS256", "hmac", secret, early)), record, request.JWT(keys)).Code).To(Equal(http.StatusOK))
+		Expect(serve(bearer(signJWT("HS256", "hmac", secret, early)), record, request.JWT(keys, request.ClockSkew(0))).Code).
+			To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should require an expiry unless told otherwise", func() {
+		forever := valid()
+		delete(forever, "exp")
+		resp := serve(bearer(signJWT("HS256", "hmac", secret, forever)), record, request.JWT(keys))
+		Expect(resp.Code).To(Equal(http.StatusUnauthorized))
+		Expect(resp.Header().Get("WWW-Authenticate")).To(ContainSubstring(`error_description="token has no expiry"`))
+
+		resp = serve(bearer(signJWT("HS256", "hmac", secret, forever)), record, request.JWT(keys, request.RequireExpiry(false)))
+		Expect(resp.Code).To(Equal(http.StatusOK))
+	})
+
+	It("should reject ES256 keys that are not on the P-256 curve", func() {
+		p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		_, err := request.Keys{"ec": &p384.PublicKey}.Key("ec")
+		Expect(err).To(MatchError("key 'ec' is not on the P-256 curve"))
+
+		resp := serve(bearer(signJWT("ES256", "ec", ecKey, valid())), record, request.JWT(request.Keys{"ec": &p384.PublicKey}))
+		Expect(resp.Code).To(Equal(http.StatusInternalServerError))
+		Expect(claims).To(BeNil())
+	})
+
+	It("should check the audience and issuer", func() {
+		token := signJWT("HS256", "hmac", secret, valid())
+		Expect(serve(bearer(token), record, request.JWT(keys, request.Audience("cakes", "pies"), request.Issuer("https://auth.example.com"))).Code).
+			To(Equal(http.StatusOK))
+		Expect(serve(bearer(token), record, request.JWT(keys, request.Audience("cakes"))).Code).To(Equal(http.StatusUnauthorized))
+		Expect(serve(bearer(token), record, request.JWT(keys, request.Issuer("https://evil.example.com"))).Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should require scopes", func() {
+		token := signJWT("HS256", "hmac", secret, valid())
+		Expect(serve(bearer(token), record, request.JWT(keys), request.RequireScopes("pies:write")).Code).To(Equal(http.StatusOK))
+
+		resp := serve(bearer(token), record, request.JWT(keys), request.RequireScopes("pies:read", "pies:delete"))
+		Expect(resp.Code).To(Equal(http.StatusForbidden))
+		Expect(resp.Header().Get("WWW-Authenticate")).To(Equal(`Bearer error="insufficient_scope", scope="pies:read pies:delete"`))
+
+		Expect(serve(bearer(""), record, request.RequireScopes("pies:read")).Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should require scopes on a route", func() {
+		router := canis.NewRouter()
+		router.GET("/pies", func(ctx canis.ParamContext, w http.ResponseWriter, r *http.Request) {})
+		router.DELETE("/pies", func(ctx canis.ParamContext, w http.ResponseWriter, r *http.Request) {},
+			canis.Use(request.RequireScopes("pies:delete")))
+		handler := canis.Chain(request.JWT(keys)).Then(router)
+
+		token := signJWT("ES256", "ec", ecKey, valid())
+		for method, status := range map[string]int{"GET": http.StatusOK, "DELETE": http.StatusForbidden} {
+			req, _ := http.NewRequest(method, "/pies", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			Expect(resp.Code).To(Equal(status), method)
+		}
+	})
+
+	It("should load keys from a JWKS file", func() {
+		file, _ := ioutil.TempFile("", "jwks")
+		defer os.Remove(file.Name())
+		file.Write(jwksFor(map[string]interface{}{"hmac": secret, "rsa": &rsaKey.PublicKey, "ec": &ecKey.PublicKey}))
+		file.Close()
+
+		keys, err := request.JWKSFile(file.Name())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(keys).To(HaveLen(3))
+		for _, token := range []string{
+			signJWT("HS256", "hmac", secret, valid()),
+			signJWT("RS256", "rsa", rsaKey, valid()),
+			signJWT("ES256", "ec", ecKey, valid()),
+		} {
+			Expect(serve(bearer(token), record, request.JWT(keys)).Code).To(Equal(http.StatusOK))
+		}
+	})
+
+	Describe("NewRemoteKeySet()", func() {
+		var jwks atomic.Value
+		var fetches int32
+		var delay int64
+		var server *httptest.Server
+
+		BeforeEach(func() {
+			atomic.StoreInt32(&fetches, 0)
+			atomic.StoreInt64(&delay, 0)
+			jwks.Store(jwksFor(map[string]interface{}{"rsa": &rsaKey.PublicKey}))
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&fetches, 1)
+				time.Sleep(time.Duration(atomic.LoadInt64(&delay)))
+				w.Write(jwks.Load().([]byte))
+			}))
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("should fetch the keys once until refreshed", func() {
+			remote := request.NewRemoteKeySet(server.URL, time.Hour)
+			token := signJWT("RS256", "rsa", rsaKey, valid())
+			Expect(serve(bearer(token), record, request.JWT(remote)).Code).To(Equal(http.StatusOK))
+			Expect(serve(bearer(token), record, request.JWT(remote)).Code).To(Equal(http.StatusOK))
+			Expect(atomic.LoadInt32(&fetches)).To(Equal(int32(1)))
+		})
+
+		It("should refresh the keys to pick up rotated keys", func() {
+			remote := request.NewRemoteKeySet(server.URL, 20*time.Millisecond)
+			Expect(serve(bearer(signJWT("RS256", "rsa", rsaKey, valid())), record, request.JWT(remote)).Code).To(Equal(http.StatusOK))
+
+			jwks.Store(jwksFor(map[string]interface{}{"ec": &ecKey.PublicKey}))
+			time.Sleep(30 * time.Millisecond)
+			Expect(serve(bearer(signJWT("ES256", "ec", ecKey, valid())), record, request.JWT(remote)).Code).To(Equal(http.StatusOK))
+			Expect(serve(bearer(signJWT("RS256", "rsa", rsaKey, valid())), record, request.JWT(remote)).Code).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("should use the keys it has while refreshing them", func() {
+			remote := request.NewRemoteKeySet(server.URL, 20*time.Millisecond)
+			token := signJWT("RS256", "rsa", rsaKey, valid())
+			Expect(serve(bearer(token), record, request.JWT(remote)).Code).To(Equal(http.StatusOK))
+
+			atomic.StoreInt64(&delay, int64(300*time.Millisecond))
+			time.Sleep(30 * time.Millisecond)
+			start := time.Now()
+			for i := 0; i < 3; i++ {
+				Expect(serve(bearer(token), record, request.JWT(remote)).Code).To(Equal(http.StatusOK))
+			}
+			Expect(time.Since(start)).To(BeNumerically("<", 200*time.Millisecond))
+			Eventually(func() int32 { return atomic.LoadInt32(&fetches) }).Should(Equal(int32(2)))
+		})
+
+		It("should fail with 500 if the keys can not be fetched", func() {
+			remote := request.NewRemoteKeySet(server.URL+"/missing", time.Hour)
+			jwks.Store([]byte("not json"))
+			Expect(serve(bearer(signJWT("RS256", "rsa", rsaKey, valid())), record, request.JWT(remote)).Code).To(Equal(http.StatusInternalServerError))
+		})
+	})
+})
@@ -2,8 +2,8 @@ package request
 
 import (
 	"bytes"
-	"net"
 	"net/http"
+	"regexp"
 	"strconv"
 	"time"
 
@@ -16,6 +16,8 @@ import (
 
 var PoolSize = 20000
 
+// Deprecated: RequestLogger no longer uses WrappedResponseWriter, use
+// canis.NewResponseWriter() to intercept the status code and size of a response
 type WrappedResponseWriter interface {
 	http.ResponseWriter
 	WriteLogPostfix(*bytes.Buffer)
@@ -24,58 +26,50 @@ type WrappedResponseWriter interface {
 /*
  ResponseLogger - Wrap the ResponseWriter so we can intercept the status code and the size of
 		  the response body set by upstream middleware
+
+ Deprecated: Use canis.NewResponseWriter() which reports the Status() and Size()
 */
 type ResponseLogger struct {
-	resp   http.ResponseWriter
-	status int
-	size   int
-}
-
-func (self *ResponseLogger) Header() http.Header {
-	return self.resp.Header()
+	canis.ResponseWriter
 }
 
-func (self *ResponseLogger) Write(buf []byte) (int, error) {
-	self.size += len(buf)
-	return self.resp.Write(buf)
-}
-
-func (self *ResponseLogger) WriteHeader(status int) {
-	self.status = status
-	self.resp.WriteHeader(status)
+// Deprecated: Use canis.NewResponseWriter()
+func NewResponseLogger(resp http.ResponseWriter) *ResponseLogger {
+	return &ResponseLogger{canis.NewResponseWriter(resp)}
 }
 
 func (self *ResponseLogger) WriteLogPostfix(buf *bytes.Buffer) {
 	// Status Code
-	buf.WriteString(strconv.Itoa(self.status))
+	buf.WriteString(strconv.Itoa(self.Status()))
 	buf.WriteString(" ")
 	// Result Size
-	buf.WriteString(strconv.Itoa(self.size))
+	buf.WriteString(strconv.Itoa(self.Size()))
 }
 
 /*
  ErrorResponseLogger - Same as ResponseLogger but also captures the response buffer for non HTTP 200 return codes
+
+ Deprecated: Use canis.NewResponseWriter() and capture the body with OnWrite()
 */
 type ErrorResponseLogger struct {
 	*ResponseLogger
 	errorMsg []byte
 }
 
-func (self *ErrorResponseLogger) Write(buf []byte) (int, error) {
-	// If the status NOT a 2XX error
-	if !((self.status - 200) < 100) {
-		self.errorMsg = buf
-	}
-	self.size += len(buf)
-	return self.resp.Write(buf)
+// Deprecated: Use canis.NewResponseWriter() and capture the body with OnWrite()
+func NewErrorResponseLogger(resp http.ResponseWriter) *ErrorResponseLogger {
+	self := &ErrorResponseLogger{ResponseLogger: NewResponseLogger(resp)}
+	self.OnWrite(func(buf []byte) {
+		// If the status NOT a 2XX error
+		if self.Status()/100 != 2 {
+			self.errorMsg = append(self.errorMsg[:0], buf...)
+		}
+	})
+	return self
 }
 
 func (self *ErrorResponseLogger) WriteLogPostfix(buf *bytes.Buffer) {
-	// Status Code
-	buf.WriteString(strconv.Itoa(self.status))
-	buf.WriteString(" ")
-	// Result Size
-	buf.WriteString(strconv.Itoa(self.size))
+	self.ResponseLogger.WriteLogPostfix(buf)
 
 	// Write the error message returned
 	if self.errorMsg != nil {
@@ -85,84 +79,211 @@ func (self *ErrorResponseLogger) WriteLogPostfix(buf *bytes.Buffer) {
 	}
 }
 
+type LoggerOption func(*RequestLogger)
+
+// Enable or disable the 'Server-Timing' response header, which reports the
+// timing segments added with AddTiming() to the client. Disabled by default,
+// as timings reveal the internals of the service to anyone who sends a request.
+func ServerTiming(enabled bool) LoggerOption {
+	return func(self *RequestLogger) {
+		self.serverTiming = enabled
+	}
+}
+
+// Log using the provided format, such as CommonLogFormat, CombinedLogFormat or
+// a custom template. Panics if the format is invalid. The following tokens are supported
+//
+//	%h       Remote host
+//	%l       Remote logname (always '-')
+//	%u       Remote user authenticated by BasicAuth(), APIKey(), JWT() or WithPrincipal() (or '-')
+//	%t       Time the request was received, in the format [02/Jan/2006:15:04:05 -0700]
+//	%L       The request id assigned by RequestID() (or '-')
+//	%r       First line of the request, e.g. 'GET /pies?pretty HTTP/1.1'
+//	%m       Request method
+//	%U       URL path requested
+//	%q       Query string (prepended with '?' if present)
+//	%H       Request protocol
+//	%s       Status code
+//	%b       Size of the response body in bytes, '-' if no bytes were sent
+//	%B       Size of the response body in bytes
+//	%D       Time taken to serve the request in microseconds
+//	%T       Time taken to serve the request in seconds
+//	%F       Time to first byte (when the status was written) in microseconds
+//	%S       Timing segments added with AddTiming(), e.g. 'db=12.3ms,cache=0.1ms'
+//	%{ms}T   Time taken to serve the request in the unit; 's', 'ms' or 'us'
+//	%{Foo}i  The value of the 'Foo' request header
+//	%{Foo}o  The value of the 'Foo' response header
+//	%%       A literal '%'
+func Format(format string) LoggerOption {
+	parsed, err := parseLogFormat(format)
+	if err != nil {
+		panic(err.Error())
+	}
+	return func(self *RequestLogger) {
+		self.format = parsed
+		self.defaultFields = false
+	}
+}
+
 /*
  Create a new instance of the request logger
 */
-func Logger(log logrus.StdLogger) canis.Middleware {
-	req := &RequestLogger{bpool.NewBufferPool(PoolSize), log, false}
-	return req.Handler
+func Logger(log logrus.StdLogger, options ...LoggerOption) canis.Middleware {
+	return newRequestLogger(log, false, options).Handler
 }
 
 /*
- Just like Logger() but also logs the payload if the HTTP status code is non 200
+ Just like Logger() but also logs the payload if the HTTP status code is non 200. Only
+ the first ErrorBodyLimit() bytes of text payloads are logged, use RedactFields() and
+ RedactPatterns() to keep secrets out of the log
 */
-func ErrorLogger(log logrus.StdLogger) canis.Middleware {
-	req := &RequestLogger{bpool.NewBufferPool(PoolSize), log, true}
+func ErrorLogger(log logrus.StdLogger, options ...LoggerOption) canis.Middleware {
+	return newRequestLogger(log, true, options).Handler
+}
+
+// Just like ErrorLogger() but emits one logrus entry per request with the
+// request details as fields instead of a formatted line. 5XX responses are
+// logged at error level, 4XX at warn level and everything else at info level.
+// The following fields are logged when they have a value
+//
+//	method      Request method
+//	path        URL path requested
+//	route       The route pattern matched by the Router, e.g. '/pies/:id'
+//	status      Status code
+//	bytes       Size of the response body in bytes
+//	latency_ms  Time taken to serve the request in milliseconds
+//	ttfb_ms     Time to first byte (when the status was written) in milliseconds
+//	timings     Timing segments added with AddTiming() in milliseconds
+//	remote_ip   Address of the client
+//	user        The authenticated user
+//	request_id  The id assigned by RequestID()
+//	error       The response body of non 2XX responses
+func StructuredLogger(log logrus.FieldLogger, options ...LoggerOption) canis.Middleware {
+	req := newRequestLogger(nil, true, options)
+	req.structured = log
 	return req.Handler
 }
 
+func newRequestLogger(log logrus.StdLogger, capture bool, options []LoggerOption) *RequestLogger {
+	req := &RequestLogger{
+		bufferPool:     bpool.NewBufferPool(PoolSize),
+		log:            log,
+		capture:        capture,
+		errorBodyLimit: DefaultErrorBodyLimit,
+		filter:         logFilter{sampleRate: 1},
+	}
+	Format(DefaultLogFormat)(req)
+	req.defaultFields = true
+	for _, option := range options {
+		option(req)
+	}
+	return req
+}
+
 // Writes apache style access logs using an efficient buffer pool to avoid
 // garbage collection
 type RequestLogger struct {
 	bufferPool *bpool.BufferPool
 	log        logrus.StdLogger
+	structured logrus.FieldLogger
 	capture    bool
+	format     logFormat
+	// Capture at most this many bytes of non 2XX response bodies
+	errorBodyLimit int
+	redactFields   *regexp.Regexp
+	redactPatterns []*regexp.Regexp
+	filter         logFilter
+	// Send timing segments in the 'Server-Timing' header
+	serverTiming bool
+	// Append the latency, timings and request id to lines in DefaultLogFormat
+	defaultFields bool
 }
 
 func (self *RequestLogger) Handler(handler canis.ContextHandler) canis.ContextHandler {
 	return canis.ContextHandlerFunc(func(ctx context.Context, originalResp http.ResponseWriter, req *http.Request) {
-		buf := self.bufferPool.Get()
-
-		// TODO: Parse X-Forward-Host if present
+		entry := logEntry{req: req, start: time.Now(), client: Client(ctx)}
 
-		// Add the client remote address
-		remoteAddress, _, err := net.SplitHostPort(req.RemoteAddr)
-		if err != nil {
-			remoteAddress = req.RemoteAddr
+		var matched *canis.MatchedRoute
+		if self.structured != nil || len(self.filter.skipRoutes) != 0 {
+			ctx, matched = canis.WithMatchedRoute(ctx)
 		}
+		ctx, entry.timings = withTimings(ctx)
+		ctx, entry.principal = withPrincipalRecord(ctx)
 
-		buf.WriteString(remoteAddress)
-		buf.WriteString(" - ")
-
-		// Add the authenticated user (if none then '-')
-		if req.URL.User != nil {
-			if name := req.URL.User.Username(); name != "" {
-				buf.WriteString(name)
-			} else {
-				buf.WriteString("-")
-			}
-		} else {
-			buf.WriteString("-")
+		resp := canis.NewResponseWriter(originalResp)
+		entry.resp = resp
+		if self.serverTiming {
+			resp.OnWriteHeader(func(int) {
+				if header := entry.timings.header(); header != "" {
+					resp.Header().Set("Server-Timing", header)
+				}
+			})
 		}
-
-		// Time
-		buf.WriteString(" [")
-		buf.WriteString(time.Now().Format("01/Jan/2015:01:01:01 -0600"))
-		buf.WriteString("] ")
-		// Http Verb
-		buf.WriteString(" ")
-		buf.WriteString(req.Method)
-		// Uri
-		buf.WriteString(" \"")
-		buf.WriteString(req.URL.RequestURI())
-		buf.WriteString("\" ")
-		// Proto
-		buf.WriteString(req.Proto)
-		buf.WriteString(" ")
-
-		var resp WrappedResponseWriter
+		var capture *errorCapture
 		if self.capture {
-			resp = &ErrorResponseLogger{&ResponseLogger{originalResp, 200, 0}, nil}
-		} else {
-			resp = &ResponseLogger{originalResp, 200, 0}
+			capture = &errorCapture{logger: self}
+			resp.OnWrite(func(buf []byte) {
+				capture.write(resp, buf)
+			})
+			defer capture.release()
 		}
+
 		// Call up the middleware chain
 		handler.ServeHTTP(ctx, resp, req)
+		entry.duration = time.Since(entry.start)
+		entry.firstByte = resp.FirstByte().Sub(entry.start)
+		// If nothing was written the status is sent once the handler returns
+		if !resp.Written() {
+			entry.firstByte = entry.duration
+		}
+		if matched != nil && matched.Route != nil {
+			entry.route = matched.Route.Path
+		}
+		if !self.filter.shouldLog(&entry) {
+			return
+		}
+		if capture != nil {
+			entry.errorMsg = capture.body()
+		}
+
+		if self.structured != nil {
+			self.logStructured(&entry)
+			return
+		}
+
+		buf := self.bufferPool.Get()
+		self.format.write(buf, &entry)
+
+		// Write the error message returned
+		if entry.errorMsg != nil {
+			buf.WriteString(" - ")
+			buf.Write(bytes.TrimSuffix(entry.errorMsg, []byte("\n")))
+		}
+		if self.defaultFields {
+			writeDefaultFields(buf, &entry)
+		}
 
-		resp.WriteLogPostfix(buf)
 		// Write out the log entry
 		self.log.Println(buf)
 		// Put the buffer back into the pool
 		self.bufferPool.Put(buf)
 	})
 }
+
+// Append the latency, time to first byte, timing segments and request id to lines
+// in the default format, named as the StructuredLogger() fields. The fields go
+// after the error message, so the status, size and error stay together
+func writeDefaultFields(buf *bytes.Buffer, entry *logEntry) {
+	buf.WriteString(" latency_ms=")
+	writeMillis(buf, entry.duration)
+	buf.WriteString(" ttfb_ms=")
+	writeMillis(buf, entry.firstByte)
+	if len(entry.timings.get()) != 0 {
+		buf.WriteString(" timings=")
+		entry.timings.writeLog(buf)
+	}
+	if id := entry.requestID(); id != "" {
+		buf.WriteString(" request_id=")
+		buf.WriteString(id)
+	}
+}
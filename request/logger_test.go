@@ -10,6 +10,10 @@ import (
 
 	"bufio"
 	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/Sirupsen/logrus"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -51,6 +55,43 @@ var _ = Describe("request", func() {
 			Expect(resp.Body.String()).To(Equal("payload"))
 			Expect(byteBuf.String()).To(ContainSubstring("GET \"/\" HTTP/1.1 200 7"))
 		})
+		It("should log the latency and time to first byte", func() {
+			app = canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+				time.Sleep(2 * time.Millisecond)
+				w.WriteHeader(200)
+			})
+			byteBuf := new(bytes.Buffer)
+			writer := bufio.NewWriter(byteBuf)
+
+			chain := canis.Chain(request.Logger(log.New(writer, "", 0)))
+			req, _ := http.NewRequest("GET", "/", nil)
+			chain.Then(app).ServeHTTP(httptest.NewRecorder(), req)
+			writer.Flush()
+
+			Expect(byteBuf.String()).To(MatchRegexp(`HTTP/1.1 200 0 latency_ms=([2-9]|\d{2,})[\d.]* ttfb_ms=[\d.]+\n$`))
+		})
+		It("should preserve the optional interfaces of the response writer", func() {
+			var flusher, hijacker bool
+			app = canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+				_, flusher = w.(http.Flusher)
+				_, hijacker = w.(http.Hijacker)
+				w.Write([]byte("chunk"))
+				w.(http.Flusher).Flush()
+			})
+			resp = httptest.NewRecorder()
+			writer := bufio.NewWriter(new(bytes.Buffer))
+
+			chain := canis.Chain(
+				request.Logger(log.New(writer, "", 0)),
+				request.ErrorLogger(log.New(writer, "", 0)),
+			)
+			req, _ := http.NewRequest("GET", "/", nil)
+			chain.Then(app).ServeHTTP(resp, req)
+
+			Expect(flusher).To(BeTrue())
+			Expect(hijacker).To(BeFalse())
+			Expect(resp.Flushed).To(BeTrue())
+		})
 	})
 
 	Describe("ErrorLogger()", func() {
@@ -75,4 +116,299 @@ var _ = Describe("request", func() {
 			Expect(byteBuf.String()).To(ContainSubstring("GET \"/\" HTTP/1.1 500 10 - some error"))
 		})
 	})
+
+	Describe("ErrorResponseLogger", func() {
+		It("should still write the status, size and error of the response", func() {
+			var resp request.WrappedResponseWriter = request.NewErrorResponseLogger(httptest.NewRecorder())
+			resp.WriteHeader(500)
+			resp.Write([]byte("some error\n"))
+
+			buf := new(bytes.Buffer)
+			resp.WriteLogPostfix(buf)
+			Expect(buf.String()).To(Equal("500 11 - some error"))
+
+			buf.Reset()
+			resp = request.NewResponseLogger(httptest.NewRecorder())
+			resp.Write([]byte("payload"))
+			resp.WriteLogPostfix(buf)
+			Expect(buf.String()).To(Equal("200 7"))
+		})
+	})
+
+	Describe("ErrorLogger() capture", func() {
+		serve := func(contentType string, writes []string, options ...request.LoggerOption) string {
+			app := canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+				if contentType != "" {
+					w.Header().Set("Content-Type", contentType)
+				}
+				w.WriteHeader(400)
+				buf := make([]byte, 0, 64)
+				for _, write := range writes {
+					// Reuse the buffer like a streaming encoder would
+					buf = append(buf[:0], write...)
+					w.Write(buf)
+				}
+			})
+			byteBuf := new(bytes.Buffer)
+			writer := bufio.NewWriter(byteBuf)
+
+			// Without the latency appended to the default format the error ends the line
+			options = append([]request.LoggerOption{request.Format(request.DefaultLogFormat)}, options...)
+			chain := canis.Chain(request.ErrorLogger(log.New(writer, "", 0), options...))
+			req, _ := http.NewRequest("GET", "/", nil)
+			chain.Then(app).ServeHTTP(httptest.NewRecorder(), req)
+			writer.Flush()
+			return byteBuf.String()
+		}
+
+		It("should capture the body across writes", func() {
+			line := serve("application/json", []string{`{"error":`, ` "bad pie"}`})
+			Expect(line).To(HaveSuffix(` - {"error": "bad pie"}` + "\n"))
+		})
+
+		It("should only capture up to the limit", func() {
+			line := serve("text/plain", []string{"0123456789", "abcdef"}, request.ErrorBodyLimit(12))
+			Expect(line).To(HaveSuffix(" - 0123456789ab...\n"))
+		})
+
+		It("should skip bodies that are not text", func() {
+			line := serve("image/png", []string{"\x89PNG"})
+			Expect(line).To(HaveSuffix(" 400 4\n"))
+			line = serve("", []string{"\x00\x01\x02"})
+			Expect(line).To(HaveSuffix(" 400 3\n"))
+		})
+
+		It("should redact fields and patterns", func() {
+			line := serve("application/json",
+				[]string{`{"token": "a\"bc", "card": 4111111111111111, "user": "joe", "ssn": "123-45-6789"}`},
+				request.RedactFields("token", "card"), request.RedactPatterns(`\d{3}-\d{2}-\d{4}`))
+			Expect(line).To(HaveSuffix(` - {"token": "[REDACTED]", "card": "[REDACTED]", "user": "joe", "ssn": "[REDACTED]"}` + "\n"))
+		})
+
+		It("should redact fields cut short by the limit", func() {
+			line := serve("application/json", []string{`{"user": "joe", "token": "secret"}`},
+				request.ErrorBodyLimit(30), request.RedactFields("token"))
+			Expect(line).To(HaveSuffix(` - {"user": "joe", "token": "[REDACTED]"...` + "\n"))
+		})
+
+		It("should panic if a pattern is invalid", func() {
+			Expect(func() { request.RedactPatterns("(") }).To(Panic())
+		})
+	})
+
+	Describe("Logger() filtering", func() {
+		serve := func(path string, status int, delay time.Duration, options ...request.LoggerOption) string {
+			router := canis.NewRouter()
+			router.GET("/pies/:id", func(ctx canis.ParamContext, w http.ResponseWriter, r *http.Request) {
+				time.Sleep(delay)
+				w.WriteHeader(status)
+			})
+			router.GET("/healthz", func(ctx canis.ParamContext, w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(status)
+			})
+			byteBuf := new(bytes.Buffer)
+			writer := bufio.NewWriter(byteBuf)
+
+			chain := canis.Chain(request.Logger(log.New(writer, "", 0), options...))
+			req, _ := http.NewRequest("GET", path, nil)
+			chain.Then(router).ServeHTTP(httptest.NewRecorder(), req)
+			writer.Flush()
+			return byteBuf.String()
+		}
+
+		It("should skip paths unless the request failed", func() {
+			Expect(serve("/healthz", 200, 0, request.SkipPaths("/healthz"))).To(BeEmpty())
+			Expect(serve("/healthz", 503, 0, request.SkipPaths("/healthz"))).To(ContainSubstring(" 503 "))
+			Expect(serve("/pies/1", 200, 0, request.SkipPaths("/healthz"))).To(ContainSubstring(" 200 "))
+		})
+
+		It("should skip route patterns", func() {
+			Expect(serve("/pies/1", 200, 0, request.SkipRoutes("/pies/:id"))).To(BeEmpty())
+			Expect(serve("/healthz", 200, 0, request.SkipRoutes("/pies/:id"))).To(ContainSubstring(" 200 "))
+		})
+
+		It("should only log the status classes requested and server errors", func() {
+			Expect(serve("/pies/1", 200, 0, request.StatusClasses(4))).To(BeEmpty())
+			Expect(serve("/pies/1", 404, 0, request.StatusClasses(4))).To(ContainSubstring(" 404 "))
+			Expect(serve("/pies/1", 500, 0, request.StatusClasses(4))).To(ContainSubstring(" 500 "))
+		})
+
+		It("should sample successful requests", func() {
+			Expect(serve("/pies/1", 200, 0, request.SampleRate(0))).To(BeEmpty())
+			Expect(serve("/pies/1", 200, 0, request.SampleRate(1))).To(ContainSubstring(" 200 "))
+			Expect(serve("/pies/1", 400, 0, request.SampleRate(0))).To(ContainSubstring(" 400 "))
+			Expect(func() { request.SampleRate(1.5) }).To(Panic())
+		})
+
+		It("should always log slow requests", func() {
+			options := []request.LoggerOption{request.SkipRoutes("/pies/:id"), request.SlowThreshold(10 * time.Millisecond)}
+			Expect(serve("/pies/1", 200, 0, options...)).To(BeEmpty())
+			Expect(serve("/pies/1", 200, 20*time.Millisecond, options...)).To(ContainSubstring(" 200 "))
+		})
+	})
+
+	Describe("StructuredLogger()", func() {
+		serve := func(path string, status int, body string) map[string]interface{} {
+			router := canis.NewRouter()
+			router.GET("/pies/:id", func(ctx canis.ParamContext, w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(status)
+				w.Write([]byte(body))
+			})
+
+			byteBuf := new(bytes.Buffer)
+			logger := logrus.New()
+			logger.Out = byteBuf
+			logger.Formatter = &logrus.JSONFormatter{}
+
+			chain := canis.Chain(request.StructuredLogger(logger), request.RequestID())
+			req, _ := http.NewRequest("GET", path, nil)
+			req.RemoteAddr = "10.1.1.1:4000"
+			req.Header.Set("X-Request-Id", "abc123")
+			chain.Then(router).ServeHTTP(httptest.NewRecorder(), req)
+
+			fields := make(map[string]interface{})
+			Expect(json.Unmarshal(byteBuf.Bytes(), &fields)).To(Succeed())
+			return fields
+		}
+
+		It("should log the request as fields", func() {
+			fields := serve("/pies/10", 200, "payload")
+			Expect(fields["level"]).To(Equal("info"))
+			Expect(fields["method"]).To(Equal("GET"))
+			Expect(fields["path"]).To(Equal("/pies/10"))
+			Expect(fields["route"]).To(Equal("/pies/:id"))
+			Expect(fields["status"]).To(BeNumerically("==", 200))
+			Expect(fields["bytes"]).To(BeNumerically("==", 7))
+			Expect(fields["remote_ip"]).To(Equal("10.1.1.1"))
+			Expect(fields["request_id"]).To(Equal("abc123"))
+			Expect(fields).To(HaveKey("latency_ms"))
+			Expect(fields).NotTo(HaveKey("error"))
+		})
+
+		It("should log the error body and pick the level by status class", func() {
+			fields := serve("/pies/10", 404, "no such pie\n")
+			Expect(fields["level"]).To(Equal("warning"))
+			Expect(fields["error"]).To(Equal("no such pie"))
+
+			fields = serve("/pies/10", 503, "unavailable")
+			Expect(fields["level"]).To(Equal("error"))
+		})
+
+		It("should not log a route if none matched", func() {
+			fields := serve("/cakes", 200, "")
+			Expect(fields["status"]).To(BeNumerically("==", 404))
+			Expect(fields).NotTo(HaveKey("route"))
+		})
+	})
+
+	Describe("AddTiming()", func() {
+		var byteBuf *bytes.Buffer
+		var writer *bufio.Writer
+
+		BeforeEach(func() {
+			app = canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+				request.AddTiming(ctx, "db", 12500*time.Microsecond)
+				stop := request.StartTiming(ctx, "cache")
+				stop()
+				w.WriteHeader(200)
+				request.AddTiming(ctx, "render", time.Millisecond)
+			})
+			resp = httptest.NewRecorder()
+			byteBuf = new(bytes.Buffer)
+			writer = bufio.NewWriter(byteBuf)
+		})
+
+		It("should send the segments recorded before the status in the 'Server-Timing' header", func() {
+			chain := canis.Chain(request.Logger(log.New(writer, "", 0), request.ServerTiming(true)))
+			req, _ := http.NewRequest("GET", "/", nil)
+			chain.Then(app).ServeHTTP(resp, req)
+
+			Expect(resp.Header().Get("Server-Timing")).To(MatchRegexp(`^db;dur=12.5, cache;dur=[\d.]+$`))
+		})
+
+		It("should log every segment along with the latency", func() {
+			chain := canis.Chain(request.Logger(log.New(writer, "", 0),
+				request.Format("%D %F %{ms}T %S")))
+			req, _ := http.NewRequest("GET", "/", nil)
+			chain.Then(app).ServeHTTP(resp, req)
+			writer.Flush()
+
+			Expect(resp.Header().Get("Server-Timing")).To(Equal(""))
+			Expect(byteBuf.String()).To(MatchRegexp(`^\d+ \d+ \d+ db=12.5ms,cache=[\d.]+ms,render=1ms\n$`))
+		})
+
+		It("should log the segments in the default format", func() {
+			chain := canis.Chain(request.Logger(log.New(writer, "", 0)))
+			req, _ := http.NewRequest("GET", "/", nil)
+			chain.Then(app).ServeHTTP(resp, req)
+			writer.Flush()
+
+			Expect(byteBuf.String()).To(MatchRegexp(`latency_ms=[\d.]+ ttfb_ms=[\d.]+ timings=db=12.5ms,cache=[\d.]+ms,render=1ms\n$`))
+		})
+
+		It("should do nothing without a logger", func() {
+			Expect(func() { request.AddTiming(context.Background(), "db", time.Second) }).NotTo(Panic())
+			Expect(request.Timings(context.Background())).To(BeEmpty())
+		})
+	})
+
+	Describe("Format()", func() {
+		var byteBuf *bytes.Buffer
+		var writer *bufio.Writer
+
+		BeforeEach(func() {
+			app = canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.WriteHeader(201)
+				w.Write([]byte("payload"))
+			})
+			resp = httptest.NewRecorder()
+			byteBuf = new(bytes.Buffer)
+			writer = bufio.NewWriter(byteBuf)
+		})
+
+		serve := func(format string) string {
+			chain := canis.Chain(
+				request.Logger(log.New(writer, "", 0), request.Format(format)),
+			)
+			req, _ := http.NewRequest("GET", "/pies?pretty", nil)
+			req.RemoteAddr = "10.1.1.1:4000"
+			req.Header.Set("Referer", "http://example.com")
+			req.Header.Set("User-Agent", "pie-client/1.0")
+			chain.Then(app).ServeHTTP(resp, req)
+			writer.Flush()
+			return byteBuf.String()
+		}
+
+		It("should log in the apache common log format", func() {
+			line := serve(request.CommonLogFormat)
+			Expect(line).To(MatchRegexp(`^10\.1\.1\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [-+]\d{4}\] "GET /pies\?pretty HTTP/1\.1" 201 7\n$`))
+		})
+
+		It("should log the current time", func() {
+			line := serve(`%t`)
+			Expect(line).To(ContainSubstring(time.Now().Format("02/Jan/2006")))
+		})
+
+		It("should log in the apache combined log format", func() {
+			line := serve(request.CombinedLogFormat)
+			Expect(line).To(HaveSuffix(`"GET /pies?pretty HTTP/1.1" 201 7 "http://example.com" "pie-client/1.0"` + "\n"))
+		})
+
+		It("should log using a custom template", func() {
+			line := serve(`%m %U%q %s %B %{Content-Type}o %{X-Missing}i 100%%`)
+			Expect(line).To(Equal("GET /pies?pretty 201 7 text/plain - 100%\n"))
+		})
+
+		It("should log the request duration", func() {
+			line := serve(`%D`)
+			Expect(line).To(MatchRegexp(`^\d+\n$`))
+		})
+
+		It("should panic if the format is invalid", func() {
+			Expect(func() { request.Format("%z") }).To(Panic())
+			Expect(func() { request.Format("%{Referer}") }).To(Panic())
+			Expect(func() { request.Format("%") }).To(Panic())
+		})
+	})
 })
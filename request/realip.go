@@ -0,0 +1,233 @@
+package request
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/thrawn01/canis"
+	"golang.org/x/net/context"
+)
+
+// The client as resolved by RealIP()
+type ClientInfo struct {
+	// The address of the client, without the port
+	IP string
+	// The scheme the client used; 'http' or 'https'
+	Scheme string
+	// The host the client requested
+	Host string
+}
+
+type clientInfoKey struct{}
+
+// Returns the client resolved by RealIP(), or nil if RealIP() is not in the chain
+func Client(ctx context.Context) *ClientInfo {
+	info, _ := ctx.Value(clientInfoKey{}).(*ClientInfo)
+	return info
+}
+
+// Returns the address of the client resolved by RealIP(), or an empty string
+func ClientIP(ctx context.Context) string {
+	if info := Client(ctx); info != nil {
+		return info.IP
+	}
+	return ""
+}
+
+// Resolve the real client address, scheme and host of requests that arrive
+// through the trusted proxies, which are a list of CIDRs ('10.0.0.0/8') or
+// addresses ('10.1.1.1'). Panics if a proxy is invalid.
+//
+// If the connection comes from a trusted proxy, the RFC 7239 'Forwarded' header
+// is used if present, otherwise 'X-Forwarded-For' (with 'X-Forwarded-Proto' and
+// 'X-Forwarded-Host') or 'X-Real-IP'. The hops are walked from the nearest to
+// the farthest and the first address that is not a trusted proxy is the client,
+// so clients can not spoof their address by sending these headers. Headers from
+// connections that are not from a trusted proxy are ignored.
+//
+// The result is available to later handlers through Client() and ClientIP(),
+// the access loggers use it when RealIP() comes before them in the chain.
+func RealIP(trustedProxies ...string) canis.Middleware {
+	trusted := make([]*net.IPNet, 0, len(trustedProxies))
+	for _, proxy := range trustedProxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			panic(fmt.Sprintf("invalid trusted proxy '%s'; %s", proxy, err))
+		}
+		trusted = append(trusted, network)
+	}
+
+	resolver := &realIPResolver{trusted}
+	return func(next canis.ContextHandler) canis.ContextHandler {
+		return canis.ContextHandlerFunc(func(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+			ctx = context.WithValue(ctx, clientInfoKey{}, resolver.resolve(req))
+			next.ServeHTTP(ctx, resp, req)
+		})
+	}
+}
+
+type realIPResolver struct {
+	trusted []*net.IPNet
+}
+
+func (self *realIPResolver) isTrusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range self.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// A single hop a request took, from either 'Forwarded' or the 'X-Forwarded-*' headers
+type forwardedHop struct {
+	addr   string
+	scheme string
+	host   string
+}
+
+func (self *realIPResolver) resolve(req *http.Request) *ClientInfo {
+	peer := remoteHost(req)
+	info := &ClientInfo{IP: peer, Scheme: "http", Host: req.Host}
+	if req.TLS != nil {
+		info.Scheme = "https"
+	}
+
+	if !self.isTrusted(peer) {
+		return info
+	}
+
+	var hops []forwardedHop
+	if header := req.Header["Forwarded"]; len(header) != 0 {
+		hops = parseForwarded(strings.Join(header, ","))
+	} else if header := req.Header["X-Forwarded-For"]; len(header) != 0 {
+		hops = parseXForwarded(req.Header)
+	} else if realIP := strings.TrimSpace(req.Header.Get("X-Real-Ip")); realIP != "" {
+		hops = []forwardedHop{{addr: realIP}}
+	}
+
+	// Walk from the nearest hop until we find one that is not one of our proxies
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := hops[i]
+		if net.ParseIP(hop.addr) == nil {
+			// 'unknown' or an obfuscated identifier, we can not trust anything beyond this hop
+			break
+		}
+		info.IP = hop.addr
+		if hop.scheme == "http" || hop.scheme == "https" {
+			info.Scheme = hop.scheme
+		}
+		if hop.host != "" {
+			info.Host = hop.host
+		}
+		if !self.isTrusted(hop.addr) {
+			break
+		}
+	}
+	return info
+}
+
+// Parse the 'X-Forwarded-For' header; the proto and host headers are matched to a hop by
+// position. If the counts differ the last value, set by the nearest proxy, applies to every
+// hop, as the values before it may have been sent by the client.
+func parseXForwarded(header http.Header) []forwardedHop {
+	addrs := splitHeader(header, "X-Forwarded-For")
+	schemes := splitHeader(header, "X-Forwarded-Proto")
+	hosts := splitHeader(header, "X-Forwarded-Host")
+
+	hops := make([]forwardedHop, len(addrs))
+	for i, addr := range addrs {
+		hops[i].addr = stripPort(addr)
+		hops[i].scheme = strings.ToLower(pick(schemes, i, len(addrs)))
+		hops[i].host = pick(hosts, i, len(addrs))
+	}
+	return hops
+}
+
+func pick(values []string, i, count int) string {
+	if len(values) == count {
+		return values[i]
+	}
+	if len(values) != 0 {
+		return values[len(values)-1]
+	}
+	return ""
+}
+
+func splitHeader(header http.Header, name string) []string {
+	var result []string
+	for _, line := range header[name] {
+		for _, value := range strings.Split(line, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				result = append(result, value)
+			}
+		}
+	}
+	return result
+}
+
+// Parse an RFC 7239 'Forwarded' header; 'for=192.0.2.60;proto=http;by=203.0.113.43, for="[2001:db8::1]:80"'
+func parseForwarded(header string) []forwardedHop {
+	var hops []forwardedHop
+	for _, element := range splitQuoted(header, ',') {
+		var hop forwardedHop
+		for _, pair := range splitQuoted(element, ';') {
+			pos := strings.IndexByte(pair, '=')
+			if pos == -1 {
+				continue
+			}
+			key := strings.ToLower(strings.TrimSpace(pair[:pos]))
+			value := strings.Trim(strings.TrimSpace(pair[pos+1:]), `"`)
+			switch key {
+			case "for":
+				hop.addr = stripPort(value)
+			case "proto":
+				hop.scheme = strings.ToLower(value)
+			case "host":
+				hop.host = value
+			}
+		}
+		hops = append(hops, hop)
+	}
+	return hops
+}
+
+// Split on sep, ignoring any sep inside double quotes
+func splitQuoted(value string, sep byte) []string {
+	var result []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				result = append(result, value[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(result, value[start:])
+}
+
+// Remove the port from '1.2.3.4:80', '[2001:db8::1]:80' or '[2001:db8::1]'
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
@@ -0,0 +1,110 @@
+package request_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"bufio"
+	"bytes"
+	"log"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/thrawn01/canis"
+	"github.com/thrawn01/canis/request"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("RealIP()", func() {
+	var client *request.ClientInfo
+
+	resolve := func(remoteAddr string, headers map[string]string) *request.ClientInfo {
+		client = nil
+		app := canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			client = request.Client(ctx)
+		})
+		chain := canis.Chain(request.RealIP("10.0.0.0/8", "192.168.1.1", "2001:db8::/32"))
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Host = "internal.example.com"
+		req.RemoteAddr = remoteAddr
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		chain.Then(app).ServeHTTP(httptest.NewRecorder(), req)
+		return client
+	}
+
+	It("should ignore forwarding headers from untrusted peers", func() {
+		info := resolve("203.0.113.5:4000", map[string]string{
+			"X-Forwarded-For": "1.1.1.1",
+			"X-Real-IP":       "2.2.2.2",
+		})
+		Expect(info.IP).To(Equal("203.0.113.5"))
+		Expect(info.Scheme).To(Equal("http"))
+		Expect(info.Host).To(Equal("internal.example.com"))
+	})
+
+	It("should use X-Forwarded-For and skip trusted proxies", func() {
+		info := resolve("10.1.1.1:4000", map[string]string{
+			"X-Forwarded-For":   "6.6.6.6, 198.51.100.7, 192.168.1.1, 10.2.2.2",
+			"X-Forwarded-Proto": "https",
+			"X-Forwarded-Host":  "api.example.com",
+		})
+		Expect(info.IP).To(Equal("198.51.100.7"))
+		Expect(info.Scheme).To(Equal("https"))
+		Expect(info.Host).To(Equal("api.example.com"))
+	})
+
+	It("should not trust the proto and host sent by the client to an appending proxy", func() {
+		info := resolve("10.1.1.1:4000", map[string]string{
+			"X-Forwarded-For":   "198.51.100.7",
+			"X-Forwarded-Proto": "https, http",
+			"X-Forwarded-Host":  "evil.example.com, api.example.com",
+		})
+		Expect(info.IP).To(Equal("198.51.100.7"))
+		Expect(info.Scheme).To(Equal("http"))
+		Expect(info.Host).To(Equal("api.example.com"))
+	})
+
+	It("should use X-Real-IP", func() {
+		info := resolve("10.1.1.1:4000", map[string]string{"X-Real-IP": "198.51.100.7"})
+		Expect(info.IP).To(Equal("198.51.100.7"))
+	})
+
+	It("should prefer the RFC 7239 Forwarded header", func() {
+		info := resolve("[2001:db8::1]:4000", map[string]string{
+			"Forwarded":       `for=6.6.6.6, for="[2001:db9::5]:80";proto=https;host="pies.example.com", for=10.3.3.3;proto=http`,
+			"X-Forwarded-For": "1.1.1.1",
+		})
+		Expect(info.IP).To(Equal("2001:db9::5"))
+		Expect(info.Scheme).To(Equal("https"))
+		Expect(info.Host).To(Equal("pies.example.com"))
+	})
+
+	It("should stop at an unknown hop", func() {
+		info := resolve("10.1.1.1:4000", map[string]string{"Forwarded": "for=6.6.6.6, for=unknown, for=10.3.3.3"})
+		Expect(info.IP).To(Equal("10.3.3.3"))
+	})
+
+	It("should panic on invalid proxies", func() {
+		Expect(func() { request.RealIP("10.0.0.0/99") }).To(Panic())
+		Expect(func() { request.RealIP("pies") }).To(Panic())
+	})
+
+	It("should provide the client address to the access logger", func() {
+		byteBuf := new(bytes.Buffer)
+		writer := bufio.NewWriter(byteBuf)
+		chain := canis.Chain(
+			request.RealIP("10.0.0.0/8"),
+			request.Logger(log.New(writer, "", 0), request.Format("%h")),
+		)
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "10.1.1.1:4000"
+		req.Header.Set("X-Forwarded-For", "198.51.100.7")
+		chain.ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {}).
+			ServeHTTP(httptest.NewRecorder(), req)
+		writer.Flush()
+
+		Expect(byteBuf.String()).To(Equal("198.51.100.7\n"))
+	})
+})
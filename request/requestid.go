@@ -0,0 +1,94 @@
+package request
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/thrawn01/canis"
+	"golang.org/x/net/context"
+)
+
+// The header used to pass a request id between services
+const RequestIDHeader = "X-Request-Id"
+
+// Incoming request ids longer than this are replaced
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// Identify every request with an id, reusing the 'X-Request-Id' sent by the
+// client if it is valid or generating a new one (a random UUID) if not. A
+// valid id is at most 128 characters of letters, digits and '-', '_', '.',
+// ':', '+', '/' or '='.
+//
+// The id is available to later handlers through GetRequestID(), is sent to the
+// client in the 'X-Request-Id' response header and is logged by the access
+// loggers. Use InjectRequestID() to pass it on to other services.
+func RequestID() canis.Middleware {
+	return func(next canis.ContextHandler) canis.ContextHandler {
+		return canis.ContextHandlerFunc(func(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+			id := req.Header.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			resp.Header().Set(RequestIDHeader, id)
+			next.ServeHTTP(context.WithValue(ctx, requestIDKey{}, id), resp, req)
+		})
+	}
+}
+
+// Returns the id assigned by RequestID(), or an empty string if RequestID() is not in the chain
+func GetRequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Set the 'X-Request-Id' header of an outgoing request to the id of the
+// request being handled, so the services called share the id
+//
+//	out, _ := http.NewRequest("GET", "http://inventory/pies", nil)
+//	request.InjectRequestID(ctx, out)
+//	resp, err := http.DefaultClient.Do(out)
+func InjectRequestID(ctx context.Context, out *http.Request) {
+	if id := GetRequestID(ctx); id != "" {
+		out.Header.Set(RequestIDHeader, id)
+	}
+}
+
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		switch c := id[i]; {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '+', c == '/', c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Generate a random (version 4) UUID
+func newRequestID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		panic("failed to generate a request id; " + err.Error())
+	}
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], id[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], id[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], id[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], id[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], id[10:])
+	return string(buf[:])
+}
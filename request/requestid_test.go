@@ -0,0 +1,110 @@
+package request_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"bufio"
+	"bytes"
+	"log"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/thrawn01/canis"
+	"github.com/thrawn01/canis/request"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("RequestID()", func() {
+	var id string
+
+	record := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		id = request.GetRequestID(ctx)
+	}
+
+	It("should reuse a valid incoming id", func() {
+		resp := serve(newRequest("GET", "/", nil, map[string]string{"X-Request-Id": "abc-123_x.y"}), record, request.RequestID())
+		Expect(id).To(Equal("abc-123_x.y"))
+		Expect(resp.Header().Get("X-Request-Id")).To(Equal("abc-123_x.y"))
+	})
+
+	It("should generate an id if none was sent", func() {
+		resp := serve(newRequest("GET", "/", nil, nil), record, request.RequestID())
+		Expect(id).To(MatchRegexp(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`))
+		Expect(resp.Header().Get("X-Request-Id")).To(Equal(id))
+
+		first := id
+		serve(newRequest("GET", "/", nil, nil), record, request.RequestID())
+		Expect(id).NotTo(Equal(first))
+	})
+
+	It("should replace invalid incoming ids", func() {
+		serve(newRequest("GET", "/", nil, map[string]string{"X-Request-Id": "bad id\x00"}), record, request.RequestID())
+		Expect(id).NotTo(Equal("bad id\x00"))
+		Expect(id).To(HaveLen(36))
+
+		serve(newRequest("GET", "/", nil, map[string]string{"X-Request-Id": strings.Repeat("a", 200)}), record, request.RequestID())
+		Expect(id).To(HaveLen(36))
+	})
+
+	It("should return an empty id without the middleware", func() {
+		Expect(request.GetRequestID(context.Background())).To(BeEmpty())
+	})
+
+	It("should inject the id into outgoing requests", func() {
+		ctx := context.Background()
+		out, _ := http.NewRequest("GET", "http://inventory/pies", nil)
+		request.InjectRequestID(ctx, out)
+		Expect(out.Header.Get("X-Request-Id")).To(BeEmpty())
+
+		var received string
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r.Header.Get("X-Request-Id")
+		}))
+		defer upstream.Close()
+
+		app := canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			out, _ := http.NewRequest("GET", upstream.URL, nil)
+			request.InjectRequestID(ctx, out)
+			resp, err := http.DefaultClient.Do(out)
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+		})
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Request-Id", "trace-1")
+		canis.Chain(request.RequestID()).Then(app).ServeHTTP(httptest.NewRecorder(), req)
+		Expect(received).To(Equal("trace-1"))
+	})
+
+	It("should be logged by the access logger", func() {
+		app := canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {})
+		byteBuf := new(bytes.Buffer)
+		writer := bufio.NewWriter(byteBuf)
+
+		chain := canis.Chain(request.Logger(log.New(writer, "", 0)),
+			request.RequestID())
+		req, _ := http.NewRequest("GET", "/", nil)
+		resp := httptest.NewRecorder()
+		chain.Then(app).ServeHTTP(resp, req)
+		writer.Flush()
+
+		Expect(resp.Header().Get("X-Request-Id")).NotTo(BeEmpty())
+		Expect(byteBuf.String()).To(HaveSuffix(" request_id=" + resp.Header().Get("X-Request-Id") + "\n"))
+	})
+
+	It("should not log the id sent by the client unless RequestID() accepted it", func() {
+		app := canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {})
+		byteBuf := new(bytes.Buffer)
+		writer := bufio.NewWriter(byteBuf)
+
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Request-Id", "forged\n127.0.0.1 - admin")
+		chain := canis.Chain(request.Logger(log.New(writer, "", 0)))
+		chain.Then(app).ServeHTTP(httptest.NewRecorder(), req)
+		writer.Flush()
+
+		Expect(byteBuf.String()).NotTo(ContainSubstring("forged"))
+		Expect(byteBuf.String()).NotTo(ContainSubstring("request_id="))
+	})
+})
@@ -0,0 +1,216 @@
+package request
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thrawn01/canis"
+	"golang.org/x/net/context"
+)
+
+// Replaced with the nonce of the request in the policy given to ContentSecurityPolicy()
+const NoncePlaceholder = "{nonce}"
+
+// The headers Secure() sends unless changed by an option
+var (
+	DefaultHSTSMaxAge            = 365 * 24 * time.Hour
+	DefaultFrameOptions          = "DENY"
+	DefaultReferrerPolicy        = "strict-origin-when-cross-origin"
+	DefaultPermissionsPolicy     = "camera=(), geolocation=(), microphone=(), payment=(), usb=()"
+	DefaultContentSecurityPolicy = "default-src 'self'; object-src 'none'; base-uri 'self'; frame-ancestors 'none'"
+)
+
+type SecureOption func(*secureHeaders)
+
+// Send 'Strict-Transport-Security' with the max age on HTTPS requests, zero disables it
+func HSTS(maxAge time.Duration, includeSubdomains, preload bool) SecureOption {
+	return func(self *secureHeaders) {
+		self.hsts = ""
+		if maxAge <= 0 {
+			return
+		}
+		self.hsts = fmt.Sprintf("max-age=%d", int64(maxAge/time.Second))
+		if includeSubdomains {
+			self.hsts += "; includeSubDomains"
+		}
+		if preload {
+			self.hsts += "; preload"
+		}
+	}
+}
+
+// The 'X-Frame-Options' header, an empty string disables it
+func FrameOptions(value string) SecureOption {
+	return func(self *secureHeaders) {
+		self.frameOptions = value
+	}
+}
+
+// The 'Referrer-Policy' header, an empty string disables it
+func ReferrerPolicy(policy string) SecureOption {
+	return func(self *secureHeaders) {
+		self.referrerPolicy = policy
+	}
+}
+
+// The 'Permissions-Policy' header, an empty string disables it
+func PermissionsPolicy(policy string) SecureOption {
+	return func(self *secureHeaders) {
+		self.permissionsPolicy = policy
+	}
+}
+
+// The 'Content-Security-Policy' header, an empty string disables it. Each
+// NoncePlaceholder in the policy is replaced with a nonce generated for the
+// request, which handlers get from CSPNonce() to mark their inline scripts and styles.
+//
+//	request.ContentSecurityPolicy("default-src 'self'; script-src 'self' 'nonce-{nonce}'", false)
+//
+// If reportOnly is true the policy is sent as 'Content-Security-Policy-Report-Only'.
+func ContentSecurityPolicy(policy string, reportOnly bool) SecureOption {
+	return func(self *secureHeaders) {
+		self.csp = policy
+		self.cspReportOnly = reportOnly
+	}
+}
+
+// Redirect requests that did not use HTTPS to the same URL using HTTPS
+func HTTPSRedirect() SecureOption {
+	return func(self *secureHeaders) {
+		self.httpsRedirect = true
+	}
+}
+
+// Redirect requests for any other host, such as 'www.example.com' or the
+// address of the server, to the host
+func CanonicalHost(host string) SecureOption {
+	return func(self *secureHeaders) {
+		self.canonicalHost = host
+	}
+}
+
+// In development mode 'Strict-Transport-Security' is not sent and requests are
+// not redirected, so the service can be used over plain HTTP on localhost
+func Development(development bool) SecureOption {
+	return func(self *secureHeaders) {
+		self.development = development
+	}
+}
+
+// Send the security headers every service should send; 'Strict-Transport-Security'
+// (on HTTPS requests only), 'X-Content-Type-Options: nosniff', 'X-Frame-Options',
+// 'Referrer-Policy', 'Permissions-Policy' and 'Content-Security-Policy' with the
+// defaults above, which the options change or disable. The headers are set before
+// the handler runs, so handlers can replace them for a single response.
+//
+// HTTPSRedirect() and CanonicalHost() redirect the request the same way the
+// Router does, with 301 (Moved Permanently) for GET and 307 (Temporary Redirect)
+// for other methods. The scheme and host resolved by RealIP() are used when it
+// comes before Secure() in the chain, otherwise those the server received.
+func Secure(options ...SecureOption) canis.Middleware {
+	secure := &secureHeaders{
+		frameOptions:      DefaultFrameOptions,
+		referrerPolicy:    DefaultReferrerPolicy,
+		permissionsPolicy: DefaultPermissionsPolicy,
+		csp:               DefaultContentSecurityPolicy,
+	}
+	HSTS(DefaultHSTSMaxAge, true, false)(secure)
+	for _, option := range options {
+		option(secure)
+	}
+	return secure.Handler
+}
+
+type secureHeaders struct {
+	hsts              string
+	frameOptions      string
+	referrerPolicy    string
+	permissionsPolicy string
+	csp               string
+	cspReportOnly     bool
+	httpsRedirect     bool
+	canonicalHost     string
+	development       bool
+}
+
+type cspNonceKey struct{}
+
+// Returns the Content-Security-Policy nonce generated by Secure() for the request,
+// or an empty string if the policy has no NoncePlaceholder
+func CSPNonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(cspNonceKey{}).(string)
+	return nonce
+}
+
+func (self *secureHeaders) Handler(next canis.ContextHandler) canis.ContextHandler {
+	return canis.ContextHandlerFunc(func(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+		scheme, host := requestOrigin(ctx, req)
+		if !self.development {
+			target := scheme
+			if self.httpsRedirect {
+				target = "https"
+			}
+			targetHost := host
+			if self.canonicalHost != "" {
+				targetHost = self.canonicalHost
+			}
+			if target != scheme || !strings.EqualFold(targetHost, host) {
+				canis.Redirect(resp, req, target+"://"+targetHost+req.URL.RequestURI())
+				return
+			}
+		}
+
+		header := resp.Header()
+		if self.hsts != "" && scheme == "https" && !self.development {
+			header.Set("Strict-Transport-Security", self.hsts)
+		}
+		header.Set("X-Content-Type-Options", "nosniff")
+		if self.frameOptions != "" {
+			header.Set("X-Frame-Options", self.frameOptions)
+		}
+		if self.referrerPolicy != "" {
+			header.Set("Referrer-Policy", self.referrerPolicy)
+		}
+		if self.permissionsPolicy != "" {
+			header.Set("Permissions-Policy", self.permissionsPolicy)
+		}
+		if self.csp != "" {
+			policy := self.csp
+			if strings.Contains(policy, NoncePlaceholder) {
+				nonce := newNonce()
+				policy = strings.Replace(policy, NoncePlaceholder, nonce, -1)
+				ctx = context.WithValue(ctx, cspNonceKey{}, nonce)
+			}
+			if self.cspReportOnly {
+				header.Set("Content-Security-Policy-Report-Only", policy)
+			} else {
+				header.Set("Content-Security-Policy", policy)
+			}
+		}
+		next.ServeHTTP(ctx, resp, req)
+	})
+}
+
+// Returns the scheme and host the client requested
+func requestOrigin(ctx context.Context, req *http.Request) (string, string) {
+	if info := Client(ctx); info != nil {
+		return info.Scheme, info.Host
+	}
+	if req.TLS != nil {
+		return "https", req.Host
+	}
+	return "http", req.Host
+}
+
+// Returns 128 random bits, base64 encoded
+func newNonce() string {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		panic("failed to generate a nonce; " + err.Error())
+	}
+	return base64.StdEncoding.EncodeToString(buf[:])
+}
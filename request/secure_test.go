@@ -0,0 +1,138 @@
+package request_test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/thrawn01/canis"
+	"github.com/thrawn01/canis/request"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("Secure()", func() {
+	var nonce string
+
+	page := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		nonce = request.CSPNonce(ctx)
+		w.Write([]byte("pie"))
+	}
+
+	visit := func(method, url string, secure bool) *http.Request {
+		req, _ := http.NewRequest(method, url, nil)
+		if secure {
+			req.TLS = &tls.ConnectionState{}
+		}
+		req.RemoteAddr = "10.0.0.1:1234"
+		return req
+	}
+
+	BeforeEach(func() {
+		nonce = ""
+	})
+
+	It("should send the default headers", func() {
+		resp := serve(visit("GET", "https://example.com/pies", true), page, request.Secure())
+		Expect(resp.Code).To(Equal(http.StatusOK))
+		header := resp.Header()
+		Expect(header.Get("Strict-Transport-Security")).To(Equal("max-age=31536000; includeSubDomains"))
+		Expect(header.Get("X-Content-Type-Options")).To(Equal("nosniff"))
+		Expect(header.Get("X-Frame-Options")).To(Equal(request.DefaultFrameOptions))
+		Expect(header.Get("Referrer-Policy")).To(Equal(request.DefaultReferrerPolicy))
+		Expect(header.Get("Permissions-Policy")).To(Equal(request.DefaultPermissionsPolicy))
+		Expect(header.Get("Content-Security-Policy")).To(Equal(request.DefaultContentSecurityPolicy))
+		Expect(nonce).To(BeEmpty())
+	})
+
+	It("should only send HSTS over HTTPS", func() {
+		resp := serve(visit("GET", "http://example.com/pies", false), page, request.Secure())
+		Expect(resp.Header().Get("Strict-Transport-Security")).To(BeEmpty())
+		Expect(resp.Header().Get("X-Content-Type-Options")).To(Equal("nosniff"))
+	})
+
+	It("should change or disable headers with options", func() {
+		resp := serve(visit("GET", "https://example.com/pies", true), page, request.Secure(
+			request.HSTS(time.Hour, false, true),
+			request.FrameOptions("SAMEORIGIN"),
+			request.ReferrerPolicy(""),
+			request.PermissionsPolicy(""),
+			request.ContentSecurityPolicy("", false),
+		))
+		header := resp.Header()
+		Expect(header.Get("Strict-Transport-Security")).To(Equal("max-age=3600; preload"))
+		Expect(header.Get("X-Frame-Options")).To(Equal("SAMEORIGIN"))
+		Expect(header).NotTo(HaveKey("Referrer-Policy"))
+		Expect(header).NotTo(HaveKey("Permissions-Policy"))
+		Expect(header).NotTo(HaveKey("Content-Security-Policy"))
+
+		resp = serve(visit("GET", "https://example.com/pies", true), page, request.Secure(request.HSTS(0, true, true)))
+		Expect(resp.Header()).NotTo(HaveKey("Strict-Transport-Security"))
+	})
+
+	It("should generate a nonce per request for the policy", func() {
+		secure := request.Secure(request.ContentSecurityPolicy("script-src 'nonce-{nonce}'; style-src 'nonce-{nonce}'", false))
+		resp := serve(visit("GET", "https://example.com/pies", true), page, secure)
+		Expect(nonce).To(MatchRegexp(`^[A-Za-z0-9+/]{22}==$`))
+		Expect(resp.Header().Get("Content-Security-Policy")).To(Equal(
+			"script-src 'nonce-" + nonce + "'; style-src 'nonce-" + nonce + "'"))
+
+		first := nonce
+		serve(visit("GET", "https://example.com/pies", true), page, secure)
+		Expect(nonce).NotTo(Equal(first))
+
+		resp = serve(visit("GET", "https://example.com/pies", true), page, request.Secure(request.ContentSecurityPolicy("script-src 'nonce-{nonce}'", true)))
+		Expect(resp.Header()).NotTo(HaveKey("Content-Security-Policy"))
+		Expect(resp.Header().Get("Content-Security-Policy-Report-Only")).To(Equal("script-src 'nonce-" + nonce + "'"))
+	})
+
+	It("should redirect to HTTPS with 301 for GET and 307 otherwise", func() {
+		secure := request.Secure(request.HTTPSRedirect())
+		resp := serve(visit("GET", "http://example.com/pies?flavor=apple", false), page, secure)
+		Expect(resp.Code).To(Equal(http.StatusMovedPermanently))
+		Expect(resp.Header().Get("Location")).To(Equal("https://example.com/pies?flavor=apple"))
+
+		resp = serve(visit("POST", "http://example.com/pies", false), page, secure)
+		Expect(resp.Code).To(Equal(http.StatusTemporaryRedirect))
+		Expect(resp.Header().Get("Location")).To(Equal("https://example.com/pies"))
+
+		resp = serve(visit("GET", "https://example.com/pies", true), page, secure)
+		Expect(resp.Code).To(Equal(http.StatusOK))
+	})
+
+	It("should use the scheme and host resolved by RealIP()", func() {
+		req, _ := http.NewRequest("GET", "http://10.0.0.5/pies", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-Forwarded-For", "203.0.113.9")
+		req.Header.Set("X-Forwarded-Proto", "https")
+		req.Header.Set("X-Forwarded-Host", "example.com")
+		resp := httptest.NewRecorder()
+		canis.Chain(request.RealIP("10.0.0.0/8"), request.Secure(request.HTTPSRedirect(), request.CanonicalHost("example.com"))).
+			ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {}).ServeHTTP(resp, req)
+		Expect(resp.Code).To(Equal(http.StatusOK))
+		Expect(resp.Header().Get("Strict-Transport-Security")).NotTo(BeEmpty())
+	})
+
+	It("should redirect to the canonical host", func() {
+		secure := request.Secure(request.CanonicalHost("example.com"))
+		resp := serve(visit("GET", "https://www.example.com/pies", true), page, secure)
+		Expect(resp.Code).To(Equal(http.StatusMovedPermanently))
+		Expect(resp.Header().Get("Location")).To(Equal("https://example.com/pies"))
+
+		resp = serve(visit("DELETE", "http://www.example.com/pies/1", false), page, request.Secure(request.CanonicalHost("example.com"), request.HTTPSRedirect()))
+		Expect(resp.Code).To(Equal(http.StatusTemporaryRedirect))
+		Expect(resp.Header().Get("Location")).To(Equal("https://example.com/pies/1"))
+
+		Expect(serve(visit("GET", "https://EXAMPLE.com/pies", true), page, secure).Code).To(Equal(http.StatusOK))
+	})
+
+	It("should not redirect or send HSTS in development mode", func() {
+		secure := request.Secure(request.Development(true), request.HTTPSRedirect(), request.CanonicalHost("example.com"))
+		resp := serve(visit("GET", "https://localhost:8080/pies", true), page, secure)
+		Expect(resp.Code).To(Equal(http.StatusOK))
+		Expect(resp.Header()).NotTo(HaveKey("Strict-Transport-Security"))
+		Expect(resp.Header().Get("X-Content-Type-Options")).To(Equal("nosniff"))
+	})
+})
@@ -0,0 +1,314 @@
+package request
+
+import (
+	"bytes"
+	"container/heap"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thrawn01/canis"
+	"golang.org/x/net/context"
+)
+
+// The header VerifySignature() reads unless SignatureHeader() is used
+const DefaultSignatureHeader = "X-Signature"
+
+var (
+	// How far the timestamp of a signature may be from now unless SignatureTolerance() is used
+	DefaultSignatureTolerance = 5 * time.Minute
+	// The largest body VerifySignature() reads unless SignatureMaxBody() is used
+	DefaultSignatureMaxBody int64 = 1 << 20
+	// How many accepted signatures are remembered to reject replays unless SignatureReplayLimit() is used
+	DefaultSignatureReplayLimit = 100000
+)
+
+// The secrets signatures are verified with. A signature made with any of the
+// secrets is accepted, so to rotate a secret add the new one, have the
+// partner switch to it and then remove the old one. Safe for concurrent use.
+type Keyring struct {
+	mutex   sync.RWMutex
+	secrets [][]byte
+}
+
+func NewKeyring(secrets ...[]byte) *Keyring {
+	keyring := &Keyring{}
+	for _, secret := range secrets {
+		keyring.Add(secret)
+	}
+	return keyring
+}
+
+// Accept signatures made with the secret
+func (self *Keyring) Add(secret []byte) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.secrets = append(self.secrets, append([]byte(nil), secret...))
+}
+
+// Stop accepting signatures made with the secret
+func (self *Keyring) Remove(secret []byte) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	secrets := self.secrets[:0]
+	for _, existing := range self.secrets {
+		if !bytes.Equal(existing, secret) {
+			secrets = append(secrets, existing)
+		}
+	}
+	self.secrets = secrets
+}
+
+// True if any of the secrets made the signature (in hex) of the payload
+func (self *Keyring) verify(payload []byte, signatures []string) bool {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	for _, secret := range self.secrets {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(payload)
+		expected := mac.Sum(nil)
+		for _, signature := range signatures {
+			decoded, err := hex.DecodeString(signature)
+			if err == nil && hmac.Equal(expected, decoded) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the signature header value for the body, signed with the secret at
+// the time; 't=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">'. For
+// partners and tests that need to sign requests.
+func Sign(secret []byte, timestamp time.Time, body []byte) string {
+	unix := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(signedPayload(unix, body))
+	return "t=" + unix + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func signedPayload(timestamp string, body []byte) []byte {
+	payload := make([]byte, 0, len(timestamp)+1+len(body))
+	payload = append(payload, timestamp...)
+	payload = append(payload, '.')
+	return append(payload, body...)
+}
+
+type SignatureOption func(*signatureVerifier)
+
+// Read the signature from the header
+func SignatureHeader(name string) SignatureOption {
+	return func(self *signatureVerifier) {
+		self.header = name
+	}
+}
+
+// Accept signatures with a timestamp up to window before or after now
+func SignatureTolerance(window time.Duration) SignatureOption {
+	return func(self *signatureVerifier) {
+		self.tolerance = window
+	}
+}
+
+// Reject bodies larger than size bytes
+func SignatureMaxBody(size int64) SignatureOption {
+	return func(self *signatureVerifier) {
+		self.maxBody = size
+	}
+}
+
+// Remember at most limit accepted signatures to reject replays, zero for no limit. Once the limit
+// is reached the signature closest to leaving the tolerance is forgotten first
+func SignatureReplayLimit(limit int) SignatureOption {
+	return func(self *signatureVerifier) {
+		self.replayLimit = limit
+	}
+}
+
+// Verify the HMAC-SHA256 signature of the request body, as sent by webhooks.
+// The signature header (DefaultSignatureHeader unless SignatureHeader() is
+// used) has the form created by Sign(); the unix time the request was signed
+// and one or more hex signatures of "<unix time>.<body>", more than one while
+// the partner rotates secrets.
+//
+//	X-Signature: t=1492774577,v1=5257a869e7ecebeda32affa62cdca3fa51cad7e77a0e56ff536d0ce8e108d8bd
+//
+// The body is read up to SignatureMaxBody() and restored for the handler.
+// Requests with a signature that no secret in the keyring made are rejected
+// with 401 (Unauthorized), as are replays; a timestamp further than
+// SignatureTolerance() from now, or the same timestamp and body seen before
+// within the tolerance, remembering up to SignatureReplayLimit() signatures.
+// Requests without a valid signature header are rejected with 400 (Bad
+// Request) and bodies over the limit with 413 (Request Entity Too Large).
+func VerifySignature(keyring *Keyring, options ...SignatureOption) canis.Middleware {
+	verifier := &signatureVerifier{
+		keyring:     keyring,
+		header:      DefaultSignatureHeader,
+		tolerance:   DefaultSignatureTolerance,
+		maxBody:     DefaultSignatureMaxBody,
+		replayLimit: DefaultSignatureReplayLimit,
+		seen:        make(map[string]struct{}),
+	}
+	for _, option := range options {
+		option(verifier)
+	}
+	return verifier.Handler
+}
+
+type signatureVerifier struct {
+	keyring     *Keyring
+	header      string
+	tolerance   time.Duration
+	maxBody     int64
+	replayLimit int
+
+	mutex sync.Mutex
+	// Hashes of the payloads accepted within the tolerance
+	seen map[string]struct{}
+	// The same hashes by when they can be forgotten
+	expiry replayQueue
+}
+
+func (self *signatureVerifier) Handler(next canis.ContextHandler) canis.ContextHandler {
+	return canis.ContextHandlerFunc(func(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+		timestamp, signatures := parseSignature(req.Header.Get(self.header))
+		if timestamp == "" || len(signatures) == 0 {
+			canis.Error(resp, "missing or malformed '"+self.header+"' header", http.StatusBadRequest)
+			return
+		}
+		unix, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			canis.Error(resp, "malformed '"+self.header+"' timestamp", http.StatusBadRequest)
+			return
+		}
+		signed := time.Unix(unix, 0)
+		if age := time.Since(signed); age > self.tolerance || age < -self.tolerance {
+			canis.Error(resp, "signature timestamp is outside the allowed window", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := self.readBody(req)
+		if err != nil {
+			if err == canis.ErrBodyTooLarge {
+				bodyTooLarge(resp)
+				return
+			}
+			canis.Error(resp, "unable to read request body; "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		payload := signedPayload(timestamp, body)
+		if !self.keyring.verify(payload, signatures) {
+			canis.Error(resp, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		if !self.remember(payload, signed) {
+			canis.Error(resp, "signature was already used", http.StatusUnauthorized)
+			return
+		}
+
+		if req.Body != nil && req.Body != http.NoBody {
+			req.Body = &decodedBody{bytes.NewReader(body), req.Body}
+			req.ContentLength = int64(len(body))
+		}
+		next.ServeHTTP(ctx, resp, req)
+	})
+}
+
+// Read the body, failing with canis.ErrBodyTooLarge if it is larger than the limit
+func (self *signatureVerifier) readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if self.maxBody > 0 && req.ContentLength > self.maxBody {
+		return nil, canis.ErrBodyTooLarge
+	}
+	reader := io.Reader(req.Body)
+	if self.maxBody > 0 {
+		reader = io.LimitReader(req.Body, self.maxBody+1)
+	}
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if self.maxBody > 0 && int64(len(body)) > self.maxBody {
+		return nil, canis.ErrBodyTooLarge
+	}
+	return body, nil
+}
+
+// Returns false if the signed payload was seen before, otherwise remembers it until it is outside the tolerance
+func (self *signatureVerifier) remember(payload []byte, signed time.Time) bool {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	now := time.Now()
+	for len(self.expiry) != 0 && now.After(self.expiry[0].expires) {
+		self.forgetFirst()
+	}
+	sum := sha256.Sum256(payload)
+	key := string(sum[:])
+	if _, ok := self.seen[key]; ok {
+		return false
+	}
+	if self.replayLimit > 0 && len(self.seen) >= self.replayLimit {
+		self.forgetFirst()
+	}
+	self.seen[key] = struct{}{}
+	heap.Push(&self.expiry, replayEntry{key, signed.Add(self.tolerance)})
+	return true
+}
+
+func (self *signatureVerifier) forgetFirst() {
+	entry := heap.Pop(&self.expiry).(replayEntry)
+	delete(self.seen, entry.key)
+}
+
+type replayEntry struct {
+	key     string
+	expires time.Time
+}
+
+// A min heap of remembered payloads, the first to expire on top. Timestamps may be
+// anywhere within the tolerance so payloads do not expire in the order they arrive
+type replayQueue []replayEntry
+
+func (self replayQueue) Len() int           { return len(self) }
+func (self replayQueue) Less(i, j int) bool { return self[i].expires.Before(self[j].expires) }
+func (self replayQueue) Swap(i, j int)      { self[i], self[j] = self[j], self[i] }
+
+func (self *replayQueue) Push(entry interface{}) {
+	*self = append(*self, entry.(replayEntry))
+}
+
+func (self *replayQueue) Pop() interface{} {
+	old := *self
+	entry := old[len(old)-1]
+	*self = old[:len(old)-1]
+	return entry
+}
+
+// Returns the timestamp and signatures of a 't=...,v1=...' header
+func parseSignature(header string) (string, []string) {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		pos := strings.IndexByte(part, '=')
+		if pos == -1 {
+			continue
+		}
+		switch key, value := strings.TrimSpace(part[:pos]), strings.TrimSpace(part[pos+1:]); key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	return timestamp, signatures
+}
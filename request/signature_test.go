@@ -0,0 +1,134 @@
+package request_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/thrawn01/canis"
+	"github.com/thrawn01/canis/request"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("VerifySignature()", func() {
+	oldSecret := []byte("old-secret")
+	newSecret := []byte("new-secret")
+	var received string
+
+	receive := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		received = string(data)
+	}
+
+	webhook := func(body, signature string) *http.Request {
+		return newRequest("POST", "/webhooks/pies", strings.NewReader(body), map[string]string{"X-Signature": signature})
+	}
+
+	BeforeEach(func() {
+		received = ""
+	})
+
+	It("should accept a valid signature and restore the body", func() {
+		body := `{"event": "pie.baked"}`
+		verify := request.VerifySignature(request.NewKeyring(newSecret))
+		resp := serve(webhook(body, request.Sign(newSecret, time.Now(), []byte(body))), receive, verify)
+		Expect(resp.Code).To(Equal(http.StatusOK))
+		Expect(received).To(Equal(body))
+	})
+
+	It("should reject signatures of another body or secret", func() {
+		verify := request.VerifySignature(request.NewKeyring(newSecret))
+		signature := request.Sign(newSecret, time.Now(), []byte("original"))
+		Expect(serve(webhook("tampered", signature), receive, verify).Code).To(Equal(http.StatusUnauthorized))
+
+		signature = request.Sign([]byte("wrong"), time.Now(), []byte("original"))
+		Expect(serve(webhook("original", signature), receive, verify).Code).To(Equal(http.StatusUnauthorized))
+		Expect(received).To(BeEmpty())
+	})
+
+	It("should reject missing or malformed headers", func() {
+		verify := request.VerifySignature(request.NewKeyring(newSecret))
+		Expect(serve(webhook("body", ""), receive, verify).Code).To(Equal(http.StatusBadRequest))
+		Expect(serve(webhook("body", "v1=abcd"), receive, verify).Code).To(Equal(http.StatusBadRequest))
+		Expect(serve(webhook("body", "t=yesterday,v1=abcd"), receive, verify).Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("should accept any secret in the keyring while rotating", func() {
+		keyring := request.NewKeyring(oldSecret, newSecret)
+		verify := request.VerifySignature(keyring)
+		Expect(serve(webhook("one", request.Sign(oldSecret, time.Now(), []byte("one"))), receive, verify).Code).To(Equal(http.StatusOK))
+		Expect(serve(webhook("two", request.Sign(newSecret, time.Now(), []byte("two"))), receive, verify).Code).To(Equal(http.StatusOK))
+
+		keyring.Remove(oldSecret)
+		Expect(serve(webhook("three", request.Sign(oldSecret, time.Now(), []byte("three"))), receive, verify).Code).To(Equal(http.StatusUnauthorized))
+
+		// A sender rotating its secret may send a signature for each
+		now := time.Now()
+		old := request.Sign(oldSecret, now, []byte("four"))
+		current := request.Sign(newSecret, now, []byte("four"))
+		header := old + "," + current[strings.Index(current, "v1="):]
+		Expect(serve(webhook("four", header), receive, verify).Code).To(Equal(http.StatusOK))
+	})
+
+	It("should reject replays outside the window", func() {
+		verify := request.VerifySignature(request.NewKeyring(newSecret), request.SignatureTolerance(time.Minute))
+		old := time.Now().Add(-2 * time.Minute)
+		Expect(serve(webhook("body", request.Sign(newSecret, old, []byte("body"))), receive, verify).Code).To(Equal(http.StatusUnauthorized))
+		future := time.Now().Add(2 * time.Minute)
+		Expect(serve(webhook("body", request.Sign(newSecret, future, []byte("body"))), receive, verify).Code).To(Equal(http.StatusUnauthorized))
+		recent := time.Now().Add(-30 * time.Second)
+		Expect(serve(webhook("body", request.Sign(newSecret, recent, []byte("body"))), receive, verify).Code).To(Equal(http.StatusOK))
+	})
+
+	It("should reject a replay within the window", func() {
+		verify := request.VerifySignature(request.NewKeyring(newSecret))
+		signature := request.Sign(newSecret, time.Now(), []byte("body"))
+		Expect(serve(webhook("body", signature), receive, verify).Code).To(Equal(http.StatusOK))
+		Expect(serve(webhook("body", signature), receive, verify).Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should forget the signatures closest to expiring past the replay limit", func() {
+		verify := request.VerifySignature(request.NewKeyring(newSecret), request.SignatureReplayLimit(2))
+		older := request.Sign(newSecret, time.Now().Add(-time.Minute), []byte("older"))
+		newer := request.Sign(newSecret, time.Now(), []byte("newer"))
+		Expect(serve(webhook("newer", newer), receive, verify).Code).To(Equal(http.StatusOK))
+		Expect(serve(webhook("older", older), receive, verify).Code).To(Equal(http.StatusOK))
+		Expect(serve(webhook("newest", request.Sign(newSecret, time.Now(), []byte("newest"))), receive, verify).Code).To(Equal(http.StatusOK))
+
+		Expect(serve(webhook("newer", newer), receive, verify).Code).To(Equal(http.StatusUnauthorized))
+		Expect(serve(webhook("older", older), receive, verify).Code).To(Equal(http.StatusOK))
+	})
+
+	It("should limit the size of the body", func() {
+		verify := request.VerifySignature(request.NewKeyring(newSecret), request.SignatureMaxBody(10))
+		body := "this body is too large"
+		Expect(serve(webhook(body, request.Sign(newSecret, time.Now(), []byte(body))), receive, verify).Code).
+			To(Equal(http.StatusRequestEntityTooLarge))
+		Expect(serve(webhook("small", request.Sign(newSecret, time.Now(), []byte("small"))), receive, verify).Code).To(Equal(http.StatusOK))
+	})
+
+	It("should verify the decoded body after DecodeBody()", func() {
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		writer.Write([]byte("decoded"))
+		writer.Close()
+
+		req, _ := http.NewRequest("POST", "/webhooks/pies", &compressed)
+		req.Header.Set("Content-Encoding", "gzip")
+		req.Header.Set("X-Signature", request.Sign(newSecret, time.Now(), []byte("decoded")))
+		resp := httptest.NewRecorder()
+		canis.Chain(request.DecodeBody(0, 0), request.VerifySignature(request.NewKeyring(newSecret))).
+			ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+				data, _ := ioutil.ReadAll(r.Body)
+				received = string(data)
+			}).ServeHTTP(resp, req)
+		Expect(resp.Code).To(Equal(http.StatusOK))
+		Expect(received).To(Equal("decoded"))
+	})
+})
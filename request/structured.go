@@ -0,0 +1,55 @@
+package request
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Returns the fields logged by StructuredLogger(), fields with no value are omitted
+func (self *logEntry) fields() logrus.Fields {
+	fields := logrus.Fields{
+		"method":     self.req.Method,
+		"path":       self.req.URL.Path,
+		"status":     self.resp.Status(),
+		"bytes":      self.resp.Size(),
+		"latency_ms": millis(self.duration),
+		"ttfb_ms":    millis(self.firstByte),
+		"remote_ip":  self.remoteHost(),
+	}
+	if segments := self.timings.get(); len(segments) != 0 {
+		timings := make(map[string]float64, len(segments))
+		for _, segment := range segments {
+			timings[segment.Name] += millis(segment.Duration)
+		}
+		fields["timings"] = timings
+	}
+	if self.route != "" {
+		fields["route"] = self.route
+	}
+	if user := self.user(); user != "" {
+		fields["user"] = user
+	}
+	if id := self.requestID(); id != "" {
+		fields["request_id"] = id
+	}
+	if len(self.errorMsg) != 0 {
+		fields["error"] = string(bytes.TrimSuffix(self.errorMsg, []byte("\n")))
+	}
+	return fields
+}
+
+func (self *RequestLogger) logStructured(entry *logEntry) {
+	log := self.structured.WithFields(entry.fields())
+	msg := entry.req.Method + " " + entry.req.URL.RequestURI()
+
+	switch status := entry.resp.Status(); {
+	case status >= http.StatusInternalServerError:
+		log.Error(msg)
+	case status >= http.StatusBadRequest:
+		log.Warn(msg)
+	default:
+		log.Info(msg)
+	}
+}
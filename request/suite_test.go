@@ -0,0 +1,27 @@
+package request_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/thrawn01/canis"
+)
+
+// Returns a request with the headers set, headers with an empty value are left out
+func newRequest(method, url string, body io.Reader, headers map[string]string) *http.Request {
+	req, _ := http.NewRequest(method, url, body)
+	for key, value := range headers {
+		if value != "" {
+			req.Header.Set(key, value)
+		}
+	}
+	return req
+}
+
+// Serve the request with a chain of the middleware in front of the app and return the response
+func serve(req *http.Request, app canis.ContextHandlerFunc, middleware ...interface{}) *httptest.ResponseRecorder {
+	resp := httptest.NewRecorder()
+	canis.Chain(middleware...).ThenFunc(app).ServeHTTP(resp, req)
+	return resp
+}
@@ -8,24 +8,33 @@ import (
 	"golang.org/x/net/context"
 )
 
+// Set a deadline on the context of the request, handlers are expected to
+// watch ctx.Done() and give up once the deadline passes. Use EnforceTimeout()
+// or OnTimeout() to respond on time even if the handler does not.
 func Timeout(timeout time.Duration) canis.Middleware {
 	return func(next canis.ContextHandler) canis.ContextHandler {
 		return canis.ContextHandlerFunc(func(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
-			ctx, _ = context.WithTimeout(ctx, timeout)
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
 			next.ServeHTTP(ctx, resp, req)
 		})
 	}
 }
 
+// Just like EnforceTimeout() but calls the handler to respond once the
+// timeout is reached instead of sending an error
 func OnTimeout(timeout time.Duration, handler canis.ContextHandlerFunc) canis.Middleware {
 	return func(next canis.ContextHandler) canis.ContextHandler {
-		return canis.ContextHandlerFunc(func(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
-			ctx, cancel := context.WithTimeout(ctx, timeout)
-			next.ServeHTTP(ctx, resp, req)
-			cancel()
-			if ctx.Err() == context.DeadlineExceeded {
-				handler(ctx, resp, req)
-			}
-		})
+		return canis.TimeoutHandler(next, timeout, handler)
 	}
 }
+
+// Respond with the status, usually 503 (Service Unavailable) or 504 (Gateway
+// Timeout), if the handler has not returned once the timeout is reached. See
+// canis.TimeoutHandler() for how the timeout is enforced, streaming handlers
+// should not be wrapped. The error is sent with canis.Error().
+func EnforceTimeout(timeout time.Duration, status int) canis.Middleware {
+	return OnTimeout(timeout, func(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+		canis.Error(resp, http.StatusText(status), status)
+	})
+}
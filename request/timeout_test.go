@@ -81,6 +81,16 @@ var _ = Describe("request", func() {
 			handler.ServeHTTP(resp, req)
 			Expect(resp.Body.String()).To(Equal("no timeout"))
 		})
+		It("should release the context once the request completes", func() {
+			var handlerCtx context.Context
+			app = canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+				handlerCtx = ctx
+			})
+			chain := canis.Chain(request.Timeout(time.Second))
+			req, _ := http.NewRequest("GET", "/", nil)
+			chain.Then(app).ServeHTTP(httptest.NewRecorder(), req)
+			Expect(handlerCtx.Err()).To(Equal(context.Canceled))
+		})
 	})
 	Describe("OnTimeout()", func() {
 		It("Should call the passed handler when timeout is reached", func() {
@@ -143,4 +153,67 @@ var _ = Describe("request", func() {
 			Expect(resp.Body.String()).To(Equal("no timeout"))
 		})
 	})
+	Describe("EnforceTimeout()", func() {
+		It("should send the status if the handler does not return in time", func() {
+			lateWrite := make(chan error, 1)
+			app = canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Pie", "apple")
+				w.Write([]byte("partial"))
+				<-ctx.Done()
+				time.Sleep(time.Millisecond * 10)
+				_, err := w.Write([]byte("late"))
+				lateWrite <- err
+			})
+			resp = httptest.NewRecorder()
+			chain := canis.Chain(request.EnforceTimeout(time.Millisecond*50, http.StatusGatewayTimeout))
+			req, _ := http.NewRequest("GET", "/", nil)
+			chain.Then(app).ServeHTTP(resp, req)
+
+			Expect(resp.Code).To(Equal(http.StatusGatewayTimeout))
+			Expect(resp.Body.String()).To(Equal("Gateway Timeout\n"))
+			Expect(resp.Header().Get("X-Pie")).To(BeEmpty())
+			Eventually(lateWrite).Should(Receive(Equal(http.ErrHandlerTimeout)))
+		})
+
+		It("should send the buffered response if the handler returns in time", func() {
+			var handlerCtx context.Context
+			app = canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+				handlerCtx = ctx
+				w.Header().Set("X-Pie", "apple")
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte("pie"))
+			})
+			resp = httptest.NewRecorder()
+			chain := canis.Chain(request.EnforceTimeout(time.Second, http.StatusServiceUnavailable))
+			req, _ := http.NewRequest("GET", "/", nil)
+			chain.Then(app).ServeHTTP(resp, req)
+
+			Expect(resp.Code).To(Equal(http.StatusCreated))
+			Expect(resp.Body.String()).To(Equal("pie"))
+			Expect(resp.Header().Get("X-Pie")).To(Equal("apple"))
+			// The context is released once the request completes
+			Expect(handlerCtx.Err()).To(Equal(context.Canceled))
+		})
+
+		It("should pass panics on to the caller", func() {
+			app = canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+				panic("bad pie")
+			})
+			chain := canis.Chain(request.EnforceTimeout(time.Second, http.StatusServiceUnavailable))
+			req, _ := http.NewRequest("GET", "/", nil)
+			Expect(func() { chain.Then(app).ServeHTTP(httptest.NewRecorder(), req) }).To(Panic())
+		})
+
+		It("should respond normally if the handler aborts", func() {
+			app = canis.ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+				canis.Abort(w, "no pie", http.StatusNotFound)
+			})
+			resp = httptest.NewRecorder()
+			chain := canis.Chain(request.EnforceTimeout(time.Second, http.StatusServiceUnavailable))
+			req, _ := http.NewRequest("GET", "/", nil)
+			chain.Then(app).ServeHTTP(resp, req)
+			Expect(resp.Code).To(Equal(http.StatusNotFound))
+			Expect(resp.Body.String()).To(Equal("no pie\n"))
+		})
+	})
 })
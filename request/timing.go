@@ -0,0 +1,114 @@
+package request
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// A named timing segment recorded by a handler, such as the time spent in the database
+type TimingSegment struct {
+	Name     string
+	Duration time.Duration
+}
+
+type timings struct {
+	mutex    sync.Mutex
+	segments []TimingSegment
+}
+
+type timingsKey struct{}
+
+// Returns a copy of the context that collects timing segments for the request
+func withTimings(ctx context.Context) (context.Context, *timings) {
+	result := &timings{}
+	return context.WithValue(ctx, timingsKey{}, result), result
+}
+
+// Record a named timing segment for the request. The name should be a valid
+// HTTP token (letters, digits and '-', '_' or '.') as it may be sent in the
+// 'Server-Timing' header, see ServerTiming(). Does nothing unless the request passed through
+// Logger(), ErrorLogger() or StructuredLogger().
+func AddTiming(ctx context.Context, name string, duration time.Duration) {
+	if t, ok := ctx.Value(timingsKey{}).(*timings); ok {
+		t.mutex.Lock()
+		t.segments = append(t.segments, TimingSegment{name, duration})
+		t.mutex.Unlock()
+	}
+}
+
+// Start a named timing segment, the segment is recorded when the returned func is called
+//
+//	defer request.StartTiming(ctx, "db")()
+func StartTiming(ctx context.Context, name string) func() {
+	start := time.Now()
+	return func() {
+		AddTiming(ctx, name, time.Since(start))
+	}
+}
+
+// Returns the timing segments recorded for the request so far
+func Timings(ctx context.Context) []TimingSegment {
+	if t, ok := ctx.Value(timingsKey{}).(*timings); ok {
+		return t.get()
+	}
+	return nil
+}
+
+func (self *timings) get() []TimingSegment {
+	if self == nil {
+		return nil
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return append([]TimingSegment(nil), self.segments...)
+}
+
+// Format the segments as the value of a 'Server-Timing' header; 'db;dur=12.3, cache;dur=0.1'
+func (self *timings) header() string {
+	segments := self.get()
+	if len(segments) == 0 {
+		return ""
+	}
+	var buf bytes.Buffer
+	for i, segment := range segments {
+		if i != 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(segment.Name)
+		buf.WriteString(";dur=")
+		writeMillis(&buf, segment.Duration)
+	}
+	return buf.String()
+}
+
+// Write the segments for the '%S' log token; 'db=12.3ms,cache=0.1ms'
+func (self *timings) writeLog(buf *bytes.Buffer) {
+	segments := self.get()
+	if len(segments) == 0 {
+		buf.WriteByte('-')
+		return
+	}
+	for i, segment := range segments {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(segment.Name)
+		buf.WriteByte('=')
+		writeMillis(buf, segment.Duration)
+		buf.WriteString("ms")
+	}
+}
+
+// Write the duration as milliseconds with up to 3 decimal places
+func writeMillis(buf *bytes.Buffer, duration time.Duration) {
+	var scratch [32]byte
+	buf.Write(strconv.AppendFloat(scratch[:0], millis(duration), 'f', -1, 64))
+}
+
+func millis(duration time.Duration) float64 {
+	return float64(duration/time.Microsecond) / 1000
+}
@@ -0,0 +1,306 @@
+package canis
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ResponseWriter wraps an http.ResponseWriter to track the status code, the
+// size of the body and when the first byte was written. Middleware can
+// register hooks to run before the status is sent or as the body is written.
+//
+// The ResponseWriter returned by NewResponseWriter() implements exactly the
+// optional interfaces (http.Flusher, http.Hijacker, http.Pusher and
+// http.CloseNotifier) that the wrapped writer implements, so wrapping the
+// writer never breaks streaming, websockets or HTTP/2 push. Middleware that
+// buffers or transforms the response uses NewFilterWriter() for the same reason.
+type ResponseWriter interface {
+	http.ResponseWriter
+	// The status code sent, 200 if the handler did not call WriteHeader()
+	Status() int
+	// The number of body bytes written
+	Size() int
+	// True once the status has been sent
+	Written() bool
+	// When the status was sent, the zero time if it has not been sent
+	FirstByte() time.Time
+	// Register a func called once, just before the status is sent. Headers set by the func are sent
+	OnWriteHeader(func(status int))
+	// Register a func called with every buffer before it is written
+	OnWrite(func(buf []byte))
+	// Returns the wrapped http.ResponseWriter
+	Unwrap() http.ResponseWriter
+}
+
+// Wrap the http.ResponseWriter, if it is already a ResponseWriter it is
+// returned unchanged so hooks from every middleware share one wrapper
+func NewResponseWriter(w http.ResponseWriter) ResponseWriter {
+	if rw, ok := w.(ResponseWriter); ok {
+		return rw
+	}
+	rw := &responseWriter{resp: w, out: w, status: http.StatusOK}
+	return withInterfaces(rw)
+}
+
+// Intercepts what a handler sends through a ResponseWriter created by NewFilterWriter()
+type ResponseFilter interface {
+	// Called in place of sending the status to the wrapped writer
+	WriteHeader(status int)
+	// Called in place of writing to the wrapped writer
+	Write(buf []byte) (int, error)
+	// Called in place of flushing the wrapped writer, only if it is a http.Flusher
+	Flush()
+	// Called before the wrapped writer is hijacked, nothing more is sent through the filter
+	Hijacked()
+}
+
+// Returns a new ResponseWriter for w that sends the status, body and flushes
+// to the filter, for middleware that buffers or transforms the response before
+// passing it on to w. It implements the same optional interfaces as w, Hijack(),
+// Push() and CloseNotify() go straight to w.
+func NewFilterWriter(w http.ResponseWriter, filter ResponseFilter) ResponseWriter {
+	rw := &responseWriter{resp: w, out: filter, filter: filter, status: http.StatusOK}
+	return withInterfaces(rw)
+}
+
+// Returns rw implementing exactly the optional interfaces of the writer it wraps
+func withInterfaces(rw *responseWriter) ResponseWriter {
+	w := rw.resp
+	const (
+		isFlusher = 1 << iota
+		isHijacker
+		isPusher
+		isCloseNotifier
+	)
+	var kind int
+	if _, ok := w.(http.Flusher); ok {
+		kind |= isFlusher
+	}
+	if _, ok := w.(http.Hijacker); ok {
+		kind |= isHijacker
+	}
+	if _, ok := w.(http.Pusher); ok {
+		kind |= isPusher
+	}
+	if _, ok := w.(http.CloseNotifier); ok {
+		kind |= isCloseNotifier
+	}
+
+	f, h, p, c := flusher{rw}, hijacker{rw}, pusher{rw}, closeNotifier{rw}
+	switch kind {
+	case 0:
+		return rw
+	case isFlusher:
+		return struct {
+			*responseWriter
+			http.Flusher
+		}{rw, f}
+	case isHijacker:
+		return struct {
+			*responseWriter
+			http.Hijacker
+		}{rw, h}
+	case isFlusher | isHijacker:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+		}{rw, f, h}
+	case isPusher:
+		return struct {
+			*responseWriter
+			http.Pusher
+		}{rw, p}
+	case isFlusher | isPusher:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Pusher
+		}{rw, f, p}
+	case isHijacker | isPusher:
+		return struct {
+			*responseWriter
+			http.Hijacker
+			http.Pusher
+		}{rw, h, p}
+	case isFlusher | isHijacker | isPusher:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{rw, f, h, p}
+	case isCloseNotifier:
+		return struct {
+			*responseWriter
+			http.CloseNotifier
+		}{rw, c}
+	case isFlusher | isCloseNotifier:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.CloseNotifier
+		}{rw, f, c}
+	case isHijacker | isCloseNotifier:
+		return struct {
+			*responseWriter
+			http.Hijacker
+			http.CloseNotifier
+		}{rw, h, c}
+	case isFlusher | isHijacker | isCloseNotifier:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+			http.CloseNotifier
+		}{rw, f, h, c}
+	case isPusher | isCloseNotifier:
+		return struct {
+			*responseWriter
+			http.Pusher
+			http.CloseNotifier
+		}{rw, p, c}
+	case isFlusher | isPusher | isCloseNotifier:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Pusher
+			http.CloseNotifier
+		}{rw, f, p, c}
+	case isHijacker | isPusher | isCloseNotifier:
+		return struct {
+			*responseWriter
+			http.Hijacker
+			http.Pusher
+			http.CloseNotifier
+		}{rw, h, p, c}
+	default:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+			http.CloseNotifier
+		}{rw, f, h, p, c}
+	}
+}
+
+type responseWriter struct {
+	resp http.ResponseWriter
+	// Where the status and body are sent; resp or the filter
+	out interface {
+		WriteHeader(status int)
+		Write(buf []byte) (int, error)
+	}
+	filter        ResponseFilter
+	status        int
+	size          int
+	written       bool
+	firstByte     time.Time
+	onWriteHeader []func(int)
+	onWrite       []func([]byte)
+}
+
+func (self *responseWriter) Header() http.Header {
+	return self.resp.Header()
+}
+
+func (self *responseWriter) WriteHeader(status int) {
+	if self.written {
+		return
+	}
+	// Informational responses such as '103 Early Hints' precede the real status
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		self.out.WriteHeader(status)
+		return
+	}
+	self.status = status
+	self.markWritten()
+	self.out.WriteHeader(status)
+}
+
+func (self *responseWriter) Write(buf []byte) (int, error) {
+	if !self.written {
+		self.markWritten()
+	}
+	for _, hook := range self.onWrite {
+		hook(buf)
+	}
+	n, err := self.out.Write(buf)
+	self.size += n
+	return n, err
+}
+
+// Run the hooks and record the time, the status is about to be sent
+func (self *responseWriter) markWritten() {
+	self.written = true
+	for _, hook := range self.onWriteHeader {
+		hook(self.status)
+	}
+	self.firstByte = time.Now()
+}
+
+func (self *responseWriter) Status() int {
+	return self.status
+}
+
+func (self *responseWriter) Size() int {
+	return self.size
+}
+
+func (self *responseWriter) Written() bool {
+	return self.written
+}
+
+func (self *responseWriter) FirstByte() time.Time {
+	return self.firstByte
+}
+
+func (self *responseWriter) OnWriteHeader(hook func(status int)) {
+	self.onWriteHeader = append(self.onWriteHeader, hook)
+}
+
+func (self *responseWriter) OnWrite(hook func(buf []byte)) {
+	self.onWrite = append(self.onWrite, hook)
+}
+
+func (self *responseWriter) Unwrap() http.ResponseWriter {
+	return self.resp
+}
+
+type flusher struct{ *responseWriter }
+
+// Flushing sends the status, so the hooks must run first
+func (self flusher) Flush() {
+	if !self.written {
+		self.markWritten()
+	}
+	if self.filter != nil {
+		self.filter.Flush()
+		return
+	}
+	self.resp.(http.Flusher).Flush()
+}
+
+type hijacker struct{ *responseWriter }
+
+func (self hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if self.filter != nil {
+		self.filter.Hijacked()
+	}
+	return self.resp.(http.Hijacker).Hijack()
+}
+
+type pusher struct{ *responseWriter }
+
+func (self pusher) Push(target string, opts *http.PushOptions) error {
+	return self.resp.(http.Pusher).Push(target, opts)
+}
+
+type closeNotifier struct{ *responseWriter }
+
+func (self closeNotifier) CloseNotify() <-chan bool {
+	return self.resp.(http.CloseNotifier).CloseNotify()
+}
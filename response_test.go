@@ -0,0 +1,178 @@
+package canis
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Implements every optional interface
+type fullResponseWriter struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+	pushed   string
+}
+
+func (self *fullResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	self.hijacked = true
+	return nil, nil, nil
+}
+
+func (self *fullResponseWriter) Push(target string, _ *http.PushOptions) error {
+	self.pushed = target
+	return nil
+}
+
+func (self *fullResponseWriter) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func TestResponseWriterInterfaces(t *testing.T) {
+	// Only implements http.ResponseWriter
+	plain := NewResponseWriter(struct{ http.ResponseWriter }{httptest.NewRecorder()})
+	if _, ok := plain.(http.Flusher); ok {
+		t.Error("plain writer should not be a http.Flusher")
+	}
+
+	recorder := NewResponseWriter(httptest.NewRecorder())
+	if _, ok := recorder.(http.Flusher); !ok {
+		t.Error("recorder should be a http.Flusher")
+	}
+	if _, ok := recorder.(http.Hijacker); ok {
+		t.Error("recorder should not be a http.Hijacker")
+	}
+	if _, ok := recorder.(http.Pusher); ok {
+		t.Error("recorder should not be a http.Pusher")
+	}
+
+	full := &fullResponseWriter{ResponseRecorder: httptest.NewRecorder()}
+	w := NewResponseWriter(full)
+	if _, ok := w.(http.CloseNotifier); !ok {
+		t.Error("should be a http.CloseNotifier")
+	}
+	w.(http.Hijacker).Hijack()
+	if !full.hijacked {
+		t.Error("Hijack() was not passed through")
+	}
+	w.(http.Pusher).Push("/style.css", nil)
+	if full.pushed != "/style.css" {
+		t.Error("Push() was not passed through")
+	}
+
+	if NewResponseWriter(w) != w {
+		t.Error("wrapping a ResponseWriter should return it unchanged")
+	}
+}
+
+func TestResponseWriterTracking(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	w := NewResponseWriter(recorder)
+
+	var hookStatus int
+	var written []string
+	w.OnWriteHeader(func(status int) {
+		hookStatus = status
+		w.Header().Set("X-Hook", "called")
+	})
+	w.OnWrite(func(buf []byte) {
+		written = append(written, string(buf))
+	})
+
+	if w.Written() || !w.FirstByte().IsZero() {
+		t.Error("nothing should be written yet")
+	}
+	w.WriteHeader(http.StatusNotFound)
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write([]byte("not "))
+	w.Write([]byte("found"))
+
+	if w.Status() != http.StatusNotFound || recorder.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 got %d", w.Status())
+	}
+	if w.Size() != 9 {
+		t.Errorf("expected size 9 got %d", w.Size())
+	}
+	if !w.Written() || w.FirstByte().IsZero() {
+		t.Error("expected the status to be written")
+	}
+	if hookStatus != http.StatusNotFound || recorder.Header().Get("X-Hook") != "called" {
+		t.Error("OnWriteHeader() hook was not called before the status was sent")
+	}
+	if len(written) != 2 || written[1] != "found" {
+		t.Errorf("wrong buffers passed to OnWrite() hook %q", written)
+	}
+	if w.Unwrap() != recorder {
+		t.Error("Unwrap() should return the wrapped writer")
+	}
+}
+
+func TestResponseWriterFlush(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	w := NewResponseWriter(recorder)
+
+	called := false
+	w.OnWriteHeader(func(int) { called = true })
+	w.(http.Flusher).Flush()
+
+	if !called || !w.Written() || !recorder.Flushed {
+		t.Error("Flush() should send the status and flush the wrapped writer")
+	}
+}
+
+// Upper cases the body and records what passes through
+type upperFilter struct {
+	resp     http.ResponseWriter
+	flushed  bool
+	hijacked bool
+}
+
+func (self *upperFilter) WriteHeader(status int) {
+	self.resp.WriteHeader(status)
+}
+
+func (self *upperFilter) Write(buf []byte) (int, error) {
+	return self.resp.Write(bytes.ToUpper(buf))
+}
+
+func (self *upperFilter) Flush() {
+	self.flushed = true
+	self.resp.(http.Flusher).Flush()
+}
+
+func (self *upperFilter) Hijacked() {
+	self.hijacked = true
+}
+
+func TestFilterWriter(t *testing.T) {
+	full := &fullResponseWriter{ResponseRecorder: httptest.NewRecorder()}
+	filter := &upperFilter{resp: full}
+	w := NewFilterWriter(full, filter)
+
+	if NewResponseWriter(w) != w {
+		t.Error("wrapping a filter writer should return it unchanged")
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte("pie"))
+	w.(http.Flusher).Flush()
+	if full.Code != http.StatusCreated || full.Body.String() != "PIE" {
+		t.Errorf("expected 201 'PIE' got %d '%s'", full.Code, full.Body.String())
+	}
+	if w.Size() != 3 || !filter.flushed || !full.Flushed {
+		t.Error("expected the filter to be flushed and the size tracked")
+	}
+	if _, ok := w.(http.CloseNotifier); !ok {
+		t.Error("should be a http.CloseNotifier")
+	}
+	w.(http.Hijacker).Hijack()
+	if !filter.hijacked || !full.hijacked {
+		t.Error("the filter should be told before the writer is hijacked")
+	}
+
+	plain := NewFilterWriter(struct{ http.ResponseWriter }{httptest.NewRecorder()}, filter)
+	if _, ok := plain.(http.Flusher); ok {
+		t.Error("plain writer should not be a http.Flusher")
+	}
+}
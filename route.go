@@ -0,0 +1,153 @@
+package canis
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Route describes a route registered with a Router
+type Route struct {
+	Method string
+	// The path as registered, e.g. '/pies/:id'
+	Path string
+	// Optional name of the route, used to refer to the route from middleware such as caches
+	Name string
+	// How long the handle may take before the Router responds with RouteTimeout, zero if unlimited
+	Timeout time.Duration
+	// Called in order before the handle, once the route matched
+	Middleware []Middleware
+}
+
+// Configures a route as it is registered with a Router
+//
+//	router.GET("/reports/:id/export", exportReport, canis.Timeout(5*time.Minute))
+type RouteOption func(*Route)
+
+// Name the route
+func Name(name string) RouteOption {
+	return func(route *Route) {
+		route.Name = name
+	}
+}
+
+// Enforce a timeout on the route, see TimeoutHandler() for how the timeout is
+// enforced. Once it is reached the Router calls RouteTimeout to respond.
+func Timeout(timeout time.Duration) RouteOption {
+	return func(route *Route) {
+		route.Timeout = timeout
+	}
+}
+
+// Call the middleware before the handle of the route. Unlike middleware in the
+// chain before the Router, it only runs for the route and can read the route
+// params with ContextParams(). The middleware is of the same types canis.Chain() accepts.
+//
+//	router.DELETE("/pies/:id", deletePie, canis.Use(request.RequireScopes("pies:write")))
+func Use(middleware ...interface{}) RouteOption {
+	return func(route *Route) {
+		route.Middleware = appendMiddleware(route.Middleware, middleware...)
+	}
+}
+
+type paramsKey struct{}
+
+// Returns the params of the matched route from the context passed to route
+// middleware (see Use()) or any context derived from the ParamContext of the handle
+func ContextParams(ctx context.Context) Params {
+	switch pc := ctx.(type) {
+	case ParamContextImpl:
+		return pc.Params
+	case *ParamContextImpl:
+		return pc.Params
+	}
+	params, _ := ctx.Value(paramsKey{}).(Params)
+	return params
+}
+
+// Wrap the handle in the middleware of the route
+func routeMiddleware(route *Route, handle ParamContextHandle) ParamContextHandle {
+	if handle == nil || len(route.Middleware) == 0 {
+		return handle
+	}
+	handler := wrapMiddleware(route.Middleware, ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+		pc, ok := ctx.(ParamContext)
+		if !ok {
+			pc = &ParamContextImpl{Context: ctx, Params: ContextParams(ctx)}
+		}
+		handle(pc, w, req)
+	}))
+	return func(ctx ParamContext, w http.ResponseWriter, req *http.Request) {
+		handler.ServeHTTP(context.WithValue(ctx, paramsKey{}, ContextParams(ctx)), w, req)
+	}
+}
+
+// Filled in by the Router with the route that matched the request
+type MatchedRoute struct {
+	Route *Route
+}
+
+type matchedRouteKey struct{}
+
+// Returns a copy of the context in which any Router that handles the request
+// records the matched route. This allows middleware that runs before the
+// Router, such as access loggers, to report the route pattern instead of the
+// path once the request completes. If routers are nested the innermost match
+// is recorded. If the context already records the route, it is returned with
+// the existing MatchedRoute so several middleware can share it.
+func WithMatchedRoute(ctx context.Context) (context.Context, *MatchedRoute) {
+	if matched, ok := ctx.Value(matchedRouteKey{}).(*MatchedRoute); ok {
+		return ctx, matched
+	}
+	matched := &MatchedRoute{}
+	return context.WithValue(ctx, matchedRouteKey{}, matched), matched
+}
+
+// Wrap the handle such that it records the route if requested by WithMatchedRoute()
+func recordRoute(route *Route, handle ParamContextHandle) ParamContextHandle {
+	if handle == nil {
+		return nil
+	}
+	return func(ctx ParamContext, w http.ResponseWriter, req *http.Request) {
+		if ctx != nil {
+			if matched, ok := ctx.Value(matchedRouteKey{}).(*MatchedRoute); ok {
+				matched.Route = route
+			}
+		}
+		handle(ctx, w, req)
+	}
+}
+
+// Wrap the handle such that the timeout of the route is enforced
+func (r *Router) enforceTimeout(route *Route, handle ParamContextHandle) ParamContextHandle {
+	if handle == nil || route.Timeout <= 0 {
+		return handle
+	}
+	return func(ctx ParamContext, w http.ResponseWriter, req *http.Request) {
+		// The handle may outlive the request if it times out, so it can not use a recycled context
+		params := append(Params(nil), ContextParams(ctx)...)
+		handler := ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+			handle(&ParamContextImpl{Context: ctx, Params: params}, w, req)
+		})
+		TimeoutHandler(handler, route.Timeout, r.routeTimeout()).ServeHTTP(ctx, w, req)
+	}
+}
+
+func (r *Router) routeTimeout() ContextHandler {
+	if r.RouteTimeout != nil {
+		return r.RouteTimeout
+	}
+	return ContextHandlerFunc(func(_ context.Context, w http.ResponseWriter, _ *http.Request) {
+		Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	})
+}
+
+// Returns the routes registered with the Router, in the order they were registered
+func (r *Router) Routes() []Route {
+	result := make([]Route, len(r.routes))
+	for i, route := range r.routes {
+		result[i] = *route
+	}
+	return result
+}
@@ -0,0 +1,133 @@
+package canis
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestWithMatchedRoute(t *testing.T) {
+	router := NewRouter()
+	router.GET("/pies/:id", func(_ ParamContext, _ http.ResponseWriter, _ *http.Request) {})
+
+	ctx, matched := WithMatchedRoute(context.Background())
+	req, _ := http.NewRequest("GET", "/pies/10", nil)
+	router.ServeHTTP(ctx, httptest.NewRecorder(), req)
+
+	if matched.Route == nil || matched.Route.Method != "GET" || matched.Route.Path != "/pies/:id" {
+		t.Errorf("wrong matched route %+v", matched.Route)
+	}
+
+	ctx, matched = WithMatchedRoute(context.Background())
+	req, _ = http.NewRequest("GET", "/cakes", nil)
+	router.ServeHTTP(ctx, httptest.NewRecorder(), req)
+
+	if matched.Route != nil {
+		t.Errorf("expected no matched route got %+v", matched.Route)
+	}
+}
+
+func TestRouteTimeout(t *testing.T) {
+	router := NewRouter()
+	router.GET("/reports/:id", func(ctx ParamContext, w http.ResponseWriter, _ *http.Request) {
+		<-ctx.Done()
+		w.Write([]byte("late"))
+	}, Timeout(20*time.Millisecond))
+	router.GET("/pies/:id", func(ctx ParamContext, w http.ResponseWriter, _ *http.Request) {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("expected a deadline on the context")
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(ctx.ByName("id")))
+	}, Timeout(time.Second))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/reports/1", nil)
+	router.ServeHTTP(context.Background(), w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503 got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/pies/10", nil)
+	router.ServeHTTP(context.Background(), w, req)
+	if w.Code != http.StatusCreated || w.Body.String() != "10" {
+		t.Errorf("expected status 201 with body '10' got %d '%s'", w.Code, w.Body.String())
+	}
+
+	router.RouteTimeout = ContextHandlerFunc(func(_ context.Context, w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusGatewayTimeout)
+	})
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/reports/1", nil)
+	router.ServeHTTP(context.Background(), w, req)
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected status 504 got %d", w.Code)
+	}
+}
+
+func TestRouteMiddleware(t *testing.T) {
+	type flavorKey struct{}
+	var order []string
+	flavor := func(next ContextHandler) ContextHandler {
+		return ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+			order = append(order, "flavor")
+			if id := ContextParams(ctx).ByName("id"); id != "10" {
+				t.Errorf("expected param 'id' to be '10' got '%s'", id)
+			}
+			next.ServeHTTP(context.WithValue(ctx, flavorKey{}, "apple"), w, req)
+		})
+	}
+	deny := func(next ContextHandler) ContextHandler {
+		return ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+			order = append(order, "deny")
+			w.WriteHeader(http.StatusForbidden)
+		})
+	}
+
+	router := NewRouter()
+	router.GET("/pies/:id", func(ctx ParamContext, w http.ResponseWriter, _ *http.Request) {
+		order = append(order, "handle")
+		w.Write([]byte(ctx.ByName("id") + " " + ctx.Value(flavorKey{}).(string)))
+	}, Use(flavor))
+	router.DELETE("/pies/:id", func(ctx ParamContext, w http.ResponseWriter, _ *http.Request) {
+		order = append(order, "handle")
+	}, Use(flavor, deny), Timeout(time.Second))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/pies/10", nil)
+	router.ServeHTTP(context.Background(), w, req)
+	if w.Body.String() != "10 apple" {
+		t.Errorf("expected body '10 apple' got '%s'", w.Body.String())
+	}
+
+	order = nil
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/pies/10", nil)
+	router.ServeHTTP(context.Background(), w, req)
+	if w.Code != http.StatusForbidden || !reflect.DeepEqual(order, []string{"flavor", "deny"}) {
+		t.Errorf("expected status 403 after 'flavor' and 'deny' got %d after %v", w.Code, order)
+	}
+}
+
+func TestRouterRoutes(t *testing.T) {
+	router := NewRouter()
+	noop := func(_ ParamContext, _ http.ResponseWriter, _ *http.Request) {}
+	router.GET("/pies", noop)
+	router.POST("/reports/:id/export", noop, Timeout(5*time.Minute), Name("export"))
+
+	routes := router.Routes()
+	if len(routes) != 2 {
+		t.Fatalf("expected 2 routes got %d", len(routes))
+	}
+	if !reflect.DeepEqual(routes[0], Route{Method: "GET", Path: "/pies"}) {
+		t.Errorf("wrong route %+v", routes[0])
+	}
+	if !reflect.DeepEqual(routes[1], Route{Method: "POST", Path: "/reports/:id/export", Name: "export", Timeout: 5 * time.Minute}) {
+		t.Errorf("wrong route %+v", routes[1])
+	}
+}
@@ -78,6 +78,7 @@ package canis
 
 import (
 	"net/http"
+	"sync"
 
 	"time"
 
@@ -89,9 +90,19 @@ type ParamContext interface {
 	Done() <-chan struct{}
 	Err() error
 	ByName(string) string
+	Lookup(string) (string, bool)
+	Raw(string) (string, error)
+	Unescaped(string) (string, error)
+	Int(string) (int, error)
+	Int64(string) (int64, error)
+	Bool(string) (bool, error)
+	UUID(string) (string, error)
+	Time(name, layout string) (time.Time, error)
 	Value(key interface{}) interface{}
 }
 
+// ParamContextImpl is the ParamContext passed to each handle by the Router,
+// as a *ParamContextImpl if the Router has RecycleParams enabled.
 type ParamContextImpl struct {
 	context.Context
 	Params
@@ -140,6 +151,14 @@ func (ps Params) ByName(name string) string {
 type Router struct {
 	trees map[string]*node
 
+	// The most params of any registered route, used to size pooled Params
+	maxParams uint8
+
+	// Recycles the ParamContext handed to each handle if RecycleParams is enabled
+	contextPool sync.Pool
+	// Every route registered, for Routes()
+	routes []*Route
+
 	// Enables automatic redirection if the current route can't be matched but a
 	// handler for the path with (without) the trailing slash exists.
 	// For example if /foo/ is requested but a route only exists for /foo, the
@@ -170,17 +189,30 @@ type Router struct {
 	// Custom OPTIONS handlers take priority over automatic replies.
 	HandleOPTIONS bool
 
+	// If enabled, the ParamContext and Params handed to each handle are
+	// recycled once the handle returns, so routing a request does not allocate.
+	// Handles then receive a *ParamContextImpl instead of a ParamContextImpl
+	// and must not use the context or its params once they return, such as
+	// from a goroutine they start; copy the values needed first. Routes with a
+	// Timeout() are always given a copy, as they may outlive the request.
+	RecycleParams bool
+
 	// Configurable http.Handler which is called when no matching route is
-	// found. If it is not set, http.NotFound is used.
+	// found. If it is not set, a 404 is written with Error().
 	NotFound ContextHandler
 
 	// Configurable http.Handler which is called when a request
 	// cannot be routed and HandleMethodNotAllowed is true.
-	// If it is not set, http.Error with http.StatusMethodNotAllowed is used.
+	// If it is not set, Error() with http.StatusMethodNotAllowed is used.
 	// The "Allow" header with allowed request methods is set before the handler
 	// is called.
 	MethodNotAllowed ContextHandler
 
+	// Configurable http.Handler which is called when a route registered with
+	// a Timeout() does not complete in time.
+	// If it is not set, Error() with http.StatusServiceUnavailable is used.
+	RouteTimeout ContextHandler
+
 	// Function to handle panics recovered from http handlers.
 	// It should be used to generate a error page and return the http error code
 	// 500 (Internal Server Error).
@@ -204,38 +236,38 @@ func NewRouter() *Router {
 }
 
 // GET is a shortcut for router.Handle("GET", path, handle)
-func (r *Router) GET(path string, handle ParamContextHandle) {
-	r.Handle("GET", path, handle)
+func (r *Router) GET(path string, handle ParamContextHandle, options ...RouteOption) {
+	r.Handle("GET", path, handle, options...)
 }
 
 // HEAD is a shortcut for router.Handle("HEAD", path, handle)
-func (r *Router) HEAD(path string, handle ParamContextHandle) {
-	r.Handle("HEAD", path, handle)
+func (r *Router) HEAD(path string, handle ParamContextHandle, options ...RouteOption) {
+	r.Handle("HEAD", path, handle, options...)
 }
 
 // OPTIONS is a shortcut for router.Handle("OPTIONS", path, handle)
-func (r *Router) OPTIONS(path string, handle ParamContextHandle) {
-	r.Handle("OPTIONS", path, handle)
+func (r *Router) OPTIONS(path string, handle ParamContextHandle, options ...RouteOption) {
+	r.Handle("OPTIONS", path, handle, options...)
 }
 
 // POST is a shortcut for router.Handle("POST", path, handle)
-func (r *Router) POST(path string, handle ParamContextHandle) {
-	r.Handle("POST", path, handle)
+func (r *Router) POST(path string, handle ParamContextHandle, options ...RouteOption) {
+	r.Handle("POST", path, handle, options...)
 }
 
 // PUT is a shortcut for router.Handle("PUT", path, handle)
-func (r *Router) PUT(path string, handle ParamContextHandle) {
-	r.Handle("PUT", path, handle)
+func (r *Router) PUT(path string, handle ParamContextHandle, options ...RouteOption) {
+	r.Handle("PUT", path, handle, options...)
 }
 
 // PATCH is a shortcut for router.Handle("PATCH", path, handle)
-func (r *Router) PATCH(path string, handle ParamContextHandle) {
-	r.Handle("PATCH", path, handle)
+func (r *Router) PATCH(path string, handle ParamContextHandle, options ...RouteOption) {
+	r.Handle("PATCH", path, handle, options...)
 }
 
 // DELETE is a shortcut for router.Handle("DELETE", path, handle)
-func (r *Router) DELETE(path string, handle ParamContextHandle) {
-	r.Handle("DELETE", path, handle)
+func (r *Router) DELETE(path string, handle ParamContextHandle, options ...RouteOption) {
+	r.Handle("DELETE", path, handle, options...)
 }
 
 // Handle registers a new request handle with the given path and method.
@@ -246,7 +278,9 @@ func (r *Router) DELETE(path string, handle ParamContextHandle) {
 // This function is intended for bulk loading and to allow the usage of less
 // frequently used, non-standardized or custom methods (e.g. for internal
 // communication with a proxy).
-func (r *Router) Handle(method, path string, handle ParamContextHandle) {
+//
+// Options such as Timeout() configure the route, the resulting Route is listed by Routes().
+func (r *Router) Handle(method, path string, handle ParamContextHandle, options ...RouteOption) {
 	if path[0] != '/' {
 		panic("path must begin with '/' in path '" + path + "'")
 	}
@@ -261,23 +295,33 @@ func (r *Router) Handle(method, path string, handle ParamContextHandle) {
 		r.trees[method] = root
 	}
 
-	root.addRoute(path, handle)
+	route := &Route{Method: method, Path: path}
+	for _, option := range options {
+		option(route)
+	}
+	root.addRoute(path, recordRoute(route, r.enforceTimeout(route, routeMiddleware(route, handle))))
+	r.routes = append(r.routes, route)
+
+	if count := countParams(path); count > r.maxParams {
+		r.maxParams = count
+	}
 }
 
 // Handler is an adapter which allows the usage of an http.Handler as a
 // request handle.
-func (r *Router) Handler(method, path string, handler http.Handler) {
+func (r *Router) Handler(method, path string, handler http.Handler, options ...RouteOption) {
 	r.Handle(method, path,
 		func(_ ParamContext, w http.ResponseWriter, req *http.Request) {
 			handler.ServeHTTP(w, req)
 		},
+		options...,
 	)
 }
 
 // HandlerFunc is an adapter which allows the usage of an http.HandlerFunc as a
 // request handle.
-func (r *Router) HandlerFunc(method, path string, handler http.HandlerFunc) {
-	r.Handler(method, path, handler)
+func (r *Router) HandlerFunc(method, path string, handler http.HandlerFunc, options ...RouteOption) {
+	r.Handler(method, path, handler, options...)
 }
 
 // ServeFiles serves files from the given file system root.
@@ -316,7 +360,7 @@ func (r *Router) recv(w http.ResponseWriter, req *http.Request) {
 // the same path with an extra / without the trailing slash should be performed.
 func (r *Router) Lookup(method, path string) (ParamContextHandle, Params, bool) {
 	if root := r.trees[method]; root != nil {
-		return root.getValue(path)
+		return root.getValue(path, nil)
 	}
 	return nil, nil, false
 }
@@ -342,7 +386,7 @@ func (r *Router) allowed(path, reqMethod string) (allow string) {
 				continue
 			}
 
-			handle, _, _ := r.trees[method].getValue(path)
+			handle, _, _ := r.trees[method].getValue(path, nil)
 			if handle != nil {
 				// add request method to list of allowed methods
 				if len(allow) == 0 {
@@ -368,24 +412,37 @@ func (r *Router) ServeHTTP(ctx context.Context, w http.ResponseWriter, req *http
 	path := req.URL.Path
 
 	if root := r.trees[req.Method]; root != nil {
-		if handle, ps, tsr := root.getValue(path); handle != nil {
-			handle(ParamContextImpl{ctx, ps}, w, req)
-			return
-		} else if req.Method != "CONNECT" && path != "/" {
-			code := 301 // Permanent redirect, request with GET method
-			if req.Method != "GET" {
-				// Temporary redirect, request with same method
-				// As of Go 1.3, Go does not support status code 308.
-				code = 307
+		var pc *ParamContextImpl
+		var params Params
+		if r.RecycleParams {
+			pc = r.getContext(ctx)
+			params = pc.Params
+		}
+		handle, ps, tsr := root.getValue(path, params)
+		if handle != nil {
+			ctx = withRawParams(ctx, root, req, ps)
+			if pc != nil {
+				pc.Context = ctx
+				pc.Params = ps
+				r.serveHandle(handle, pc, w, req)
+				r.putContext(pc)
+			} else {
+				r.serveHandle(handle, ParamContextImpl{ctx, ps}, w, req)
 			}
+			return
+		}
+		if pc != nil {
+			r.putContext(pc)
+		}
 
+		if req.Method != "CONNECT" && path != "/" {
 			if tsr && r.RedirectTrailingSlash {
 				if len(path) > 1 && path[len(path)-1] == '/' {
 					req.URL.Path = path[:len(path)-1]
 				} else {
 					req.URL.Path = path + "/"
 				}
-				http.Redirect(w, req, req.URL.String(), code)
+				Redirect(w, req, req.URL.String())
 				return
 			}
 
@@ -397,7 +454,7 @@ func (r *Router) ServeHTTP(ctx context.Context, w http.ResponseWriter, req *http
 				)
 				if found {
 					req.URL.Path = string(fixedPath)
-					http.Redirect(w, req, req.URL.String(), code)
+					Redirect(w, req, req.URL.String())
 					return
 				}
 			}
@@ -420,7 +477,7 @@ func (r *Router) ServeHTTP(ctx context.Context, w http.ResponseWriter, req *http
 				if r.MethodNotAllowed != nil {
 					r.MethodNotAllowed.ServeHTTP(ctx, w, req)
 				} else {
-					http.Error(w,
+					Error(w,
 						http.StatusText(http.StatusMethodNotAllowed),
 						http.StatusMethodNotAllowed,
 					)
@@ -434,6 +491,46 @@ func (r *Router) ServeHTTP(ctx context.Context, w http.ResponseWriter, req *http
 	if r.NotFound != nil {
 		r.NotFound.ServeHTTP(ctx, w, req)
 	} else {
-		http.NotFound(w, req)
+		Error(w, "404 page not found", http.StatusNotFound)
+	}
+}
+
+// Redirect the request to url the way the Router redirects to a fixed path;
+// with 301 (Moved Permanently) for GET requests and 307 (Temporary Redirect)
+// for other methods, so the client repeats the request with the same method and body.
+func Redirect(w http.ResponseWriter, req *http.Request, url string) {
+	code := 301 // Permanent redirect, request with GET method
+	if req.Method != "GET" {
+		// Temporary redirect, request with same method
+		// As of Go 1.3, Go does not support status code 308.
+		code = 307
+	}
+	http.Redirect(w, req, url, code)
+}
+
+// Returns a ParamContext from the pool with an empty Params slice large
+// enough for any registered route
+func (r *Router) getContext(ctx context.Context) *ParamContextImpl {
+	pc, _ := r.contextPool.Get().(*ParamContextImpl)
+	if pc == nil {
+		pc = &ParamContextImpl{Params: make(Params, 0, r.maxParams)}
+	}
+	pc.Context = ctx
+	return pc
+}
+
+// Return the ParamContext to the pool, the handle must no longer be using it
+func (r *Router) putContext(pc *ParamContextImpl) {
+	for i := range pc.Params {
+		pc.Params[i] = Param{}
 	}
+	pc.Params = pc.Params[:0]
+	pc.Context = nil
+	r.contextPool.Put(pc)
+}
+
+// Call the handle, recovering from any Abort() made by the handle
+func (r *Router) serveHandle(handle ParamContextHandle, ctx ParamContext, w http.ResponseWriter, req *http.Request) {
+	defer recoverAbort()
+	handle(ctx, w, req)
 }
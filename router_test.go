@@ -531,3 +531,81 @@ func TestRouterServeFiles(t *testing.T) {
 		t.Error("serving file failed")
 	}
 }
+
+func newBenchRouter() *Router {
+	router := NewRouter()
+	router.RecycleParams = true
+	handle := func(_ ParamContext, _ http.ResponseWriter, _ *http.Request) {}
+	router.GET("/", handle)
+	router.GET("/user/:name", handle)
+	router.GET("/user/:name/pies/:id", handle)
+	router.GET("/src/*filepath", handle)
+	return router
+}
+
+func TestRouterParamsReused(t *testing.T) {
+	router := NewRouter()
+	router.RecycleParams = true
+	var first, second string
+	router.GET("/user/:name", func(ctx ParamContext, _ http.ResponseWriter, _ *http.Request) {
+		if first == "" {
+			first = ctx.ByName("name")
+			return
+		}
+		second = ctx.ByName("name")
+	})
+
+	for _, path := range []string{"/user/gopher", "/user/thrawn"} {
+		r, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(context.Background(), new(mockResponseWriter), r)
+	}
+	if first != "gopher" || second != "thrawn" {
+		t.Errorf("wrong params from pooled context; got %q and %q", first, second)
+	}
+}
+
+func TestRouterMallocs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping malloc count in short mode")
+	}
+
+	router := newBenchRouter()
+	w := new(mockResponseWriter)
+	ctx := context.Background()
+	for _, path := range []string{"/", "/user/gopher", "/user/gopher/pies/10", "/src/some/file.go"} {
+		r, _ := http.NewRequest("GET", path, nil)
+		allocs := testing.AllocsPerRun(100, func() { router.ServeHTTP(ctx, w, r) })
+		if allocs > 0 {
+			t.Errorf("ServeHTTP(%q): %v allocs, want zero", path, allocs)
+		}
+	}
+}
+
+func benchmarkRouter(b *testing.B, path string) {
+	router := newBenchRouter()
+	w := new(mockResponseWriter)
+	r, _ := http.NewRequest("GET", path, nil)
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		router.ServeHTTP(ctx, w, r)
+	}
+}
+
+func BenchmarkRouterStatic(b *testing.B) {
+	benchmarkRouter(b, "/")
+}
+
+func BenchmarkRouterParam(b *testing.B) {
+	benchmarkRouter(b, "/user/gopher")
+}
+
+func BenchmarkRouterParams(b *testing.B) {
+	benchmarkRouter(b, "/user/gopher/pies/10")
+}
+
+func BenchmarkRouterCatchAll(b *testing.B) {
+	benchmarkRouter(b, "/src/some/file.go")
+}
@@ -0,0 +1,98 @@
+package canis
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Returns a ContextHandler that runs the handler with a deadline on the
+// context and calls onTimeout to respond if the handler has not returned once
+// the timeout is reached. Works in the same way as http.TimeoutHandler; the
+// handler writes to a buffer which is only sent if it returns in time, writes
+// after the timeout return http.ErrHandlerTimeout. As the buffered writer does
+// not support http.Flusher or http.Hijacker streaming handlers should not be
+// wrapped. The context is always released once the request completes.
+func TimeoutHandler(handler ContextHandler, timeout time.Duration, onTimeout ContextHandler) ContextHandler {
+	return &timeoutHandler{handler: abortable(handler), timeout: timeout, onTimeout: onTimeout}
+}
+
+type timeoutHandler struct {
+	handler   ContextHandler
+	timeout   time.Duration
+	onTimeout ContextHandler
+}
+
+func (self *timeoutHandler) ServeHTTP(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(ctx, self.timeout)
+	defer cancel()
+
+	writer := &timeoutWriter{header: make(http.Header), status: http.StatusOK}
+	done := make(chan struct{})
+	panicChan := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				panicChan <- p
+			}
+		}()
+		self.handler.ServeHTTP(ctx, writer, req)
+		close(done)
+	}()
+
+	select {
+	case p := <-panicChan:
+		panic(p)
+	case <-done:
+		writer.mutex.Lock()
+		defer writer.mutex.Unlock()
+		header := resp.Header()
+		for key, value := range writer.header {
+			header[key] = value
+		}
+		resp.WriteHeader(writer.status)
+		resp.Write(writer.buf.Bytes())
+	case <-ctx.Done():
+		writer.mutex.Lock()
+		writer.timedOut = true
+		writer.mutex.Unlock()
+		self.onTimeout.ServeHTTP(ctx, resp, req)
+	}
+}
+
+// Buffers the response until the handler returns, writes after the timeout are discarded
+type timeoutWriter struct {
+	mutex       sync.Mutex
+	header      http.Header
+	buf         bytes.Buffer
+	status      int
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (self *timeoutWriter) Header() http.Header {
+	return self.header
+}
+
+func (self *timeoutWriter) Write(buf []byte) (int, error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	self.wroteHeader = true
+	return self.buf.Write(buf)
+}
+
+func (self *timeoutWriter) WriteHeader(status int) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.timedOut || self.wroteHeader {
+		return
+	}
+	self.wroteHeader = true
+	self.status = status
+}
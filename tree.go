@@ -315,6 +315,13 @@ func (n *node) insertChild(numParams uint8, path, fullPath string, handle ParamC
 	n.handle = handle
 }
 
+// Returns a copy of p with room for n more params
+func growParams(p Params, n uint8) Params {
+	grown := make(Params, len(p), len(p)+int(n))
+	copy(grown, p)
+	return grown
+}
+
 /*
  Not working, abandoned for a bit
 */
@@ -351,11 +358,13 @@ walk: // outer loop for walking the tree
 }
 
 // Returns the handle registered with the given path (key). The values of
-// wildcards are saved to a map.
+// wildcards are appended to ps, which is only reallocated if it lacks the
+// capacity; pass a nil ps to allocate on demand.
 // If no handle can be found, a TSR (trailing slash redirect) recommendation is
 // made if a handle exists with an extra (without the) trailing slash for the
 // given path.
-func (n *node) getValue(path string) (handle ParamContextHandle, p Params, tsr bool) {
+func (n *node) getValue(path string, ps Params) (handle ParamContextHandle, p Params, tsr bool) {
+	p = ps
 walk: // outer loop for walking the tree
 	for {
 		if len(path) > len(n.path) {
@@ -392,9 +401,9 @@ walk: // outer loop for walking the tree
 					}
 
 					// save param value
-					if p == nil {
+					if cap(p) < len(p)+int(n.maxParams) {
 						// lazy allocation
-						p = make(Params, 0, n.maxParams)
+						p = growParams(p, n.maxParams)
 					}
 					i := len(p)
 					p = p[:i+1] // expand slice within preallocated capacity
@@ -427,9 +436,9 @@ walk: // outer loop for walking the tree
 
 				case catchAll:
 					// save param value
-					if p == nil {
+					if cap(p) < len(p)+int(n.maxParams) {
 						// lazy allocation
-						p = make(Params, 0, n.maxParams)
+						p = growParams(p, n.maxParams)
 					}
 					i := len(p)
 					p = p[:i+1] // expand slice within preallocated capacity